@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -11,8 +12,8 @@ import (
 const ADOPTIUM_URL = "https://api.adoptium.net"
 
 type JavaProvider interface {
-	GetDownloads(installPath string) []Download
-	Install(installPath string) bool
+	GetDownloads(ctx context.Context, installPath string) []Download
+	Install(ctx context.Context, installPath string) bool
 	GetJavaPath(installPath string) string
 }
 
@@ -21,11 +22,11 @@ type JavaProvider interface {
 type NoOpJavaProvider struct {
 }
 
-func (e *NoOpJavaProvider) GetDownloads(installPath string) []Download {
+func (e *NoOpJavaProvider) GetDownloads(ctx context.Context, installPath string) []Download {
 	return make([]Download, 0)
 }
 
-func (e *NoOpJavaProvider) Install(installPath string) bool {
+func (e *NoOpJavaProvider) Install(ctx context.Context, installPath string) bool {
 	return true
 }
 
@@ -76,10 +77,10 @@ type AdoptiumJavaProvider struct {
 	InstallProps *InstallProperties
 }
 
-func (self *AdoptiumJavaProvider) GetDownloads(installPath string) []Download {
+func (self *AdoptiumJavaProvider) GetDownloads(ctx context.Context, installPath string) []Download {
 	downloads := make([]Download, 0)
 
-	rel, err := self.GetCompatiableAdoptiumVersion()
+	rel, err := self.GetCompatiableAdoptiumVersion(ctx)
 	if err != nil {
 		return downloads
 	}
@@ -114,25 +115,27 @@ func (self *AdoptiumJavaProvider) GetDownloads(installPath string) []Download {
 	version := InstallProperties{rel, &binary, &fullPath}
 	self.InstallProps = &version
 
-	downloads = append(downloads, Download{"jre", *parsedUrl, archiveName, "sha256", binary.Package.Checksum, fullPath})
+	downloads = append(downloads, Download{"jre", *parsedUrl, archiveName, "sha256", binary.Package.Checksum, fullPath, nil, int64(binary.Package.Size)})
 
 	return downloads
 }
 
-func (self *AdoptiumJavaProvider) Install(installPath string) bool {
+func (self *AdoptiumJavaProvider) Install(ctx context.Context, installPath string) bool {
 	if self.InstallProps != nil {
 		archivePath := *self.InstallProps.ArchivePath
 		if strings.HasSuffix(archivePath, ".zip") {
-			err := extractZip(filepath.Join(installPath, "jre"), archivePath)
+			err := extractZip(ctx, filepath.Join(installPath, "jre"), archivePath)
 			if err != nil {
+				removePartialJre(ctx, installPath)
 				printfln("Failed to extract zip: " + archivePath)
 				println(err)
 				return false
 			}
 			os.Remove(archivePath)
 		} else if strings.HasSuffix(archivePath, ".tar.gz") {
-			err := extractTarGz(filepath.Join(installPath, "jre"), archivePath)
+			err := extractTarGz(ctx, filepath.Join(installPath, "jre"), archivePath)
 			if err != nil {
+				removePartialJre(ctx, installPath)
 				printfln("Failed to extract tar.gz: " + archivePath)
 				println(err)
 				return false
@@ -146,6 +149,14 @@ func (self *AdoptiumJavaProvider) Install(installPath string) bool {
 	return true
 }
 
+// removePartialJre removes a Java runtime that was only partly extracted when
+// the install was interrupted.
+func removePartialJre(ctx context.Context, installPath string) {
+	if ctx.Err() != nil {
+		os.RemoveAll(filepath.Join(installPath, "jre"))
+	}
+}
+
 func (self *AdoptiumJavaProvider) GetJavaPath(installPath string) string {
 	var executable = "java"
 	var binFolder = "bin"
@@ -174,28 +185,28 @@ func (self *AdoptiumJavaProvider) GetJavaPath(installPath string) string {
 	return executable
 }
 
-func (self *AdoptiumJavaProvider) GetCompatiableAdoptiumVersion() (*AdoptiumRelease, error) {
+func (self *AdoptiumJavaProvider) GetCompatiableAdoptiumVersion(ctx context.Context) (*AdoptiumRelease, error) {
 	if self.SemverTarget != nil {
-		return self.GetAdoptiumReleaseViaSemver(runtime.GOARCH, true)
+		return self.GetAdoptiumReleaseViaSemver(ctx, runtime.GOARCH, true)
 	} else {
-		return self.GetLatestAdoptiumRelease(runtime.GOARCH, true)
+		return self.GetLatestAdoptiumRelease(ctx, runtime.GOARCH, true)
 	}
 }
 
-func (self *AdoptiumJavaProvider) GetAdoptiumReleaseViaSemver(architecture string, jre bool) (*AdoptiumRelease, error) {
+func (self *AdoptiumJavaProvider) GetAdoptiumReleaseViaSemver(ctx context.Context, architecture string, jre bool) (*AdoptiumRelease, error) {
 	var releases []AdoptiumRelease
 	url := ADOPTIUM_URL + "/v3/assets/version/"
 	url += *self.SemverTarget
 	url += GetAdoptiumQueryProperties(architecture, jre)
-	err := APICall(url, &releases)
+	err := APICall(ctx, url, &releases)
 	if err != nil {
 		if runtime.GOOS == "darwin" && architecture == "arm64" {
 			// We are mac M1, try x64.
-			return self.GetAdoptiumReleaseViaSemver("amd64", jre)
+			return self.GetAdoptiumReleaseViaSemver(ctx, "amd64", jre)
 		}
 		if jre {
 			// We failed to find a JRE, find a JDK instead..
-			return self.GetAdoptiumReleaseViaSemver(architecture, false)
+			return self.GetAdoptiumReleaseViaSemver(ctx, architecture, false)
 		}
 		return nil, err
 	}
@@ -203,21 +214,21 @@ func (self *AdoptiumJavaProvider) GetAdoptiumReleaseViaSemver(architecture strin
 	return &releases[0], nil
 }
 
-func (self *AdoptiumJavaProvider) GetLatestAdoptiumRelease(architecture string, jre bool) (*AdoptiumRelease, error) {
+func (self *AdoptiumJavaProvider) GetLatestAdoptiumRelease(ctx context.Context, architecture string, jre bool) (*AdoptiumRelease, error) {
 	var releases []AdoptiumRelease
 	url := ADOPTIUM_URL + "/v3/assets/feature_releases/"
 	url += *self.ShortVersion
 	url += "/ga"
 	url += GetAdoptiumQueryProperties(architecture, jre)
-	err := APICall(url, &releases)
+	err := APICall(ctx, url, &releases)
 	if err != nil {
 		if runtime.GOOS == "darwin" && architecture == "arm64" {
 			// We are mac M1, try x64.
-			return self.GetLatestAdoptiumRelease("amd64", jre)
+			return self.GetLatestAdoptiumRelease(ctx, "amd64", jre)
 		}
 		if jre {
 			// We failed to find a JRE, find a JDK instead..
-			return self.GetLatestAdoptiumRelease(architecture, false)
+			return self.GetLatestAdoptiumRelease(ctx, architecture, false)
 		}
 		return nil, err
 	}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateDir holds the installer's own files in an install: backups, staged
+// updates and pristine copies of configs.
+const stateDir = ".serverdownloader"
+
+// backupsDir holds a snapshot of the files of an install from before each
+// update, relative to the install path.
+var backupsDir = filepath.Join(stateDir, "backups")
+
+const (
+	backupManifestName = "backup.json"
+	// backupTimeFormat names snapshots so they sort oldest first.
+	backupTimeFormat = "20060102-150405"
+)
+
+// backupDirs are folders that updates remove and rebuild, so they are kept
+// whole.
+var backupDirs = []string{"libraries", "jre"}
+
+// Backup is a snapshot of the files an update was about to touch. Paths are
+// relative to the install path, and the copies are under files/ in Dir.
+type Backup struct {
+	Created   time.Time    `json:"created"`
+	ModpackID int          `json:"modpackId"`
+	Version   *planVersion `json:"version,omitempty"`
+	// Files were copied into the snapshot. Dirs are moved into it as the
+	// update replaces them.
+	Files []string `json:"files"`
+	Dirs  []string `json:"dirs"`
+	// New did not exist before the update, and are removed on rollback.
+	New []string `json:"new"`
+	// Complete is set once the update finished.
+	Complete bool `json:"complete"`
+
+	Dir string `json:"-"`
+}
+
+func (b *Backup) Name() string {
+	return filepath.Base(b.Dir)
+}
+
+// BackupPaths lists the files and folders in installPath that an update
+// changing paths can touch, along with the start scripts and mod loader files.
+func BackupPaths(installPath string, paths []string) (files []string, dirs []string) {
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if safeRelPath(path) && !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, dir := range backupDirs {
+		if dir == "jre" && Options.Nojava {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	for _, path := range paths {
+		add(filepath.Clean(path))
+	}
+	for _, path := range managedRootFiles(installPath) {
+		add(path)
+	}
+	// Files inside the kept folders are already covered.
+	kept := files[:0]
+	for _, file := range files {
+		if !inBackupDirs(file, dirs) {
+			kept = append(kept, file)
+		}
+	}
+	sort.Strings(kept)
+	return kept, dirs
+}
+
+func isBackupDir(path string) bool {
+	for _, dir := range backupDirs {
+		if path == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func inBackupDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// managedRootFiles are the files in the install root matching managedFiles,
+// such as start scripts and mod loader jars.
+func managedRootFiles(installPath string) []string {
+	var ret []string
+	for _, pattern := range managedFiles {
+		matches, _ := filepath.Glob(filepath.Join(installPath, pattern))
+		for _, match := range matches {
+			if fi, err := os.Stat(match); err == nil && fi.Mode().IsRegular() {
+				ret = append(ret, filepath.Base(match))
+			}
+		}
+	}
+	return ret
+}
+
+// safeRelPath reports whether rel stays inside the folder it is relative to.
+func safeRelPath(rel string) bool {
+	return rel != "." && !filepath.IsAbs(rel) && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// CreateBackup snapshots files of installPath before an update to a new
+// folder in backupsDir. The update removes dirs anyway, so rather than being
+// copied now they are moved into the backup as the update replaces them.
+func CreateBackup(installPath string, previous VersionInfo, files []string, dirs []string) (error, *Backup) {
+	now := time.Now()
+	base := filepath.Join(installPath, backupsDir, now.Format(backupTimeFormat))
+	dir := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			break
+		}
+		dir = fmt.Sprintf("%s-%d", base, i)
+	}
+	backup := &Backup{Created: now, ModpackID: previous.ParentId, Files: []string{}, Dirs: []string{}, New: []string{}, Dir: dir}
+	if previous.Version != nil {
+		backup.Version = &planVersion{previous.ID, previous.Name, previous.Type}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		return err, nil
+	}
+
+	for _, path := range files {
+		src := filepath.Join(installPath, path)
+		fi, err := os.Stat(src)
+		if os.IsNotExist(err) {
+			backup.New = append(backup.New, path)
+			continue
+		}
+		if err != nil {
+			return err, nil
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		LogIfVerbose("Backing up %s\n", src)
+		if err := copyWithMode(src, filepath.Join(dir, "files", path), fi.Mode()); err != nil {
+			return fmt.Errorf("unable to back up %s: %v", path, err), nil
+		}
+		backup.Files = append(backup.Files, path)
+	}
+
+	for _, path := range dirs {
+		if _, err := os.Stat(filepath.Join(installPath, path)); os.IsNotExist(err) {
+			backup.New = append(backup.New, path)
+			continue
+		}
+		backup.Dirs = append(backup.Dirs, path)
+	}
+
+	return backup.write(), backup
+}
+
+func (b *Backup) write() error {
+	raw, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(b.Dir, backupManifestName), raw, 0644)
+}
+
+// Finish marks the backup complete once the update is done, and records the
+// start scripts and mod loader files that the update added.
+func (b *Backup) Finish(installPath string) error {
+	known := make(map[string]bool)
+	for _, paths := range [][]string{b.Files, b.New} {
+		for _, path := range paths {
+			known[path] = true
+		}
+	}
+	for _, path := range managedRootFiles(installPath) {
+		if !known[path] {
+			b.New = append(b.New, path)
+		}
+	}
+	b.Complete = true
+	return b.write()
+}
+
+// Restore puts installPath back as it was when the backup was taken. The
+// backup is left in place.
+func (b *Backup) Restore(installPath string) error {
+	for _, paths := range [][]string{b.Files, b.Dirs, b.New} {
+		for _, path := range paths {
+			if !safeRelPath(path) {
+				return fmt.Errorf("backup %s has an invalid path %s", b.Name(), path)
+			}
+		}
+	}
+
+	// Deepest first, so folders left empty can go too.
+	created := append([]string{}, b.New...)
+	sort.Slice(created, func(i, j int) bool { return len(created[i]) > len(created[j]) })
+	for _, path := range created {
+		target := filepath.Join(installPath, path)
+		LogIfVerbose("Removing %s\n", target)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		removeEmptyParents(installPath, filepath.Dir(path))
+	}
+
+	for _, path := range b.Dirs {
+		src := filepath.Join(b.Dir, "files", path)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			// The update stopped before replacing it.
+			continue
+		}
+		target := filepath.Join(installPath, path)
+		LogIfVerbose("Restoring %s\n", target)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := copyTree(src, target); err != nil {
+			return fmt.Errorf("unable to restore %s: %v", path, err)
+		}
+	}
+
+	for _, path := range b.Files {
+		src := filepath.Join(b.Dir, "files", path)
+		fi, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("unable to restore %s: %v", path, err)
+		}
+		LogIfVerbose("Restoring %s\n", filepath.Join(installPath, path))
+		if err := copyWithMode(src, filepath.Join(installPath, path), fi.Mode()); err != nil {
+			return fmt.Errorf("unable to restore %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// removeEmptyParents removes dir, relative to installPath, and its parents for
+// as long as they are empty.
+func removeEmptyParents(installPath string, dir string) {
+	for ; dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if os.Remove(filepath.Join(installPath, dir)) != nil {
+			return
+		}
+	}
+}
+
+// copyWithMode copies src to dst, keeping the permissions so start scripts
+// stay executable.
+func copyWithMode(src string, dst string, mode fs.FileMode) error {
+	if err := placeFile(src, dst, false); err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode.Perm())
+}
+
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyWithMode(path, target, info.Mode())
+		}
+		return nil
+	})
+}
+
+// ListBackups reads the snapshots of installPath, oldest first.
+func ListBackups(installPath string) (error, []*Backup) {
+	dir := filepath.Join(installPath, backupsDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return err, nil
+	}
+	var backups []*Backup
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		backupDir := filepath.Join(dir, entry.Name())
+		raw, err := os.ReadFile(filepath.Join(backupDir, backupManifestName))
+		if err != nil {
+			LogIfVerbose("Skipping backup %s: %v\n", backupDir, err)
+			continue
+		}
+		backup := &Backup{}
+		if err := json.Unmarshal(raw, backup); err != nil {
+			LogIfVerbose("Skipping backup %s: %v\n", backupDir, err)
+			continue
+		}
+		backup.Dir = backupDir
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name() < backups[j].Name() })
+	return nil, backups
+}
+
+// PruneBackups removes all but the newest keep snapshots of installPath.
+func PruneBackups(installPath string, keep int) {
+	err, backups := ListBackups(installPath)
+	if err != nil {
+		LogIfVerbose("Unable to list backups: %v\n", err)
+		return
+	}
+	for i := 0; i < len(backups)-keep; i++ {
+		LogIfVerbose("Removing old backup %s\n", backups[i].Dir)
+		if err := os.RemoveAll(backups[i].Dir); err != nil {
+			printfln("Error occurred whilst removing old backup %s: %v", backups[i].Dir, err)
+		}
+	}
+}
+
+// backupUpdate snapshots everything the update to paths can touch, unless
+// backups are turned off.
+func backupUpdate(installPath string, previous VersionInfo, paths []string) *Backup {
+	if Options.Backups <= 0 {
+		return nil
+	}
+	emitPhase("backup")
+	files, dirs := BackupPaths(installPath, paths)
+	printfln("Backing up %d files and the %s folders", len(files), strings.Join(dirs, " and "))
+	err, backup := CreateBackup(installPath, previous, files, dirs)
+	if err != nil {
+		if !QuestionYN(false, "Unable to back up the install before updating: %v\nDo you wish to continue without a backup?", err) {
+			fatalf("Aborting as the install could not be backed up")
+		}
+		return nil
+	}
+	PruneBackups(installPath, Options.Backups)
+	return backup
+}
+
+// finishBackup marks the backup called name as complete, if there is one.
+func finishBackup(installPath string, name string) {
+	if len(name) == 0 {
+		return
+	}
+	err, backups := ListBackups(installPath)
+	if err != nil {
+		LogIfVerbose("Unable to list backups: %v\n", err)
+		return
+	}
+	for _, backup := range backups {
+		if backup.Name() == name {
+			if err := backup.Finish(installPath); err != nil {
+				printfln("Error occurred whilst finishing backup %s: %v", name, err)
+			}
+		}
+	}
+}
+
+func runRollback(ctx context.Context, filename string, args []string) {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n\n", strings.Join(args, " "))
+		PrintCommandUsage(filename, findCommand("rollback"))
+		os.Exit(2)
+	}
+	installPath := Options.Path
+	if len(installPath) == 0 {
+		installPath = "."
+	}
+
+	err, backups := ListBackups(installPath)
+	if err != nil {
+		fatalf("Unable to read backups: %v", err)
+	}
+	if len(backups) == 0 {
+		fatalf("No backups found in %s", filepath.Join(installPath, backupsDir))
+	}
+	backup := backups[len(backups)-1]
+
+	version := "an unknown version"
+	if backup.Version != nil {
+		version = fmt.Sprintf("version %s (%d)", backup.Version.Name, backup.Version.ID)
+	}
+	if !backup.Complete {
+		printfln("The update after backup %s did not finish", backup.Name())
+	}
+	if !QuestionYN(true, "Continuing will restore %s of modpack %d from the backup taken %s. Do you wish to continue?", version, backup.ModpackID, backup.Created.Format("2006-01-02 15:04:05")) {
+		fatalf("Aborted by user")
+	}
+
+	emitPhase("rollback")
+	if err := backup.Restore(installPath); err != nil {
+		fatalf("Error rolling back: %v", err)
+	}
+	// An update interrupted while being applied must not be finished now.
+	DiscardStaged(installPath)
+	printfln("Rolled back to %s", version)
+	exit(0, "")
+}
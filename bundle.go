@@ -0,0 +1,593 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A bundle is a tar archive, compressed with zstd or gzip, holding everything needed to install one version of a
+// pack without network access: every file the install downloads, plus every
+// API and metadata response seen whilst working out what to download. Installs
+// from a bundle replay those responses in place of the network.
+const (
+	bundleFormat       = 1
+	bundleManifestName = "manifest.json"
+	bundleVersionName  = "version.json"
+)
+
+type BundleManifest struct {
+	Format     int         `json:"format"`
+	Created    string      `json:"created"`
+	Downloader string      `json:"downloader"`
+	Modpack    planVersion `json:"modpack"`
+	Version    planVersion `json:"version"`
+	Targets    []Target    `json:"targets"`
+	Curseforge bool        `json:"curseforge"`
+	// Java is set if the bundle holds a JRE, which only runs on OS and Arch.
+	Java bool   `json:"java"`
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+	// Mirrors are the mirror options the bundle was made with. Installs use
+	// them so they ask for the same URLs.
+	Mirrors struct {
+		All      []string `json:"all,omitempty"`
+		Forge    []string `json:"forge,omitempty"`
+		NeoForge []string `json:"neoforge,omitempty"`
+		Fabric   []string `json:"fabric,omitempty"`
+		Mojang   []string `json:"mojang,omitempty"`
+		Only     bool     `json:"only,omitempty"`
+	} `json:"mirrors"`
+	Files     []planFile       `json:"files"`
+	Responses []bundleResponse `json:"responses"`
+}
+
+// bundleResponse is a recorded response to Method URL. File is the archive
+// entry holding its body, if it has one.
+type bundleResponse struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Location    string `json:"location,omitempty"`
+	File        string `json:"file,omitempty"`
+}
+
+// bundleURL is urlStr as kept in a bundle. The API key is left out so a
+// bundle made with a private key doesn't hold it, and installs with any key.
+func bundleURL(urlStr string) string {
+	if keyed := BaseAPIURL + apiKey + "/"; strings.HasPrefix(urlStr, keyed) {
+		return BaseAPIURL + "{apikey}/" + strings.TrimPrefix(urlStr, keyed)
+	}
+	return urlStr
+}
+
+func bundleKey(method string, urlStr string) string {
+	return method + " " + urlStr
+}
+
+// bundleRecorder keeps the responses to requests made until it is stopped.
+type bundleRecorder struct {
+	mu        sync.Mutex
+	stopped   bool
+	responses map[string]bundleResponse
+	bodies    map[string][]byte
+}
+
+type recordingTransport struct {
+	next     http.RoundTripper
+	recorder *bundleRecorder
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || t.recorder.isStopped() || len(req.Header.Get("Range")) > 0 {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	t.recorder.add(req, resp, body)
+	return resp, nil
+}
+
+func (r *bundleRecorder) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
+
+// stop ends recording. Downloads are added to the bundle as files instead.
+func (r *bundleRecorder) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+}
+
+func (r *bundleRecorder) add(req *http.Request, resp *http.Response, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recorded := bundleResponse{
+		Method:      req.Method,
+		URL:         bundleURL(req.URL.String()),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Location:    resp.Header.Get("Location"),
+	}
+	if len(body) > 0 {
+		recorded.File = "responses/" + strconv.Itoa(len(r.bodies))
+		r.bodies[recorded.File] = body
+	}
+	r.responses[bundleKey(recorded.Method, recorded.URL)] = recorded
+}
+
+// body is what was recorded for a GET of urlStr.
+func (r *bundleRecorder) body(urlStr string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bodies[r.responses[bundleKey(http.MethodGet, bundleURL(urlStr))].File]
+}
+
+// bundleReplay answers requests from an extracted bundle, and never from the
+// network.
+type bundleReplay struct {
+	dir       string
+	responses map[string]bundleResponse
+}
+
+func (b *bundleReplay) RoundTrip(req *http.Request) (*http.Response, error) {
+	urlStr := bundleURL(req.URL.String())
+	recorded, ok := b.responses[bundleKey(req.Method, urlStr)]
+	if !ok && req.Method == http.MethodHead {
+		recorded, ok = b.responses[bundleKey(http.MethodGet, urlStr)]
+	}
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	setStatus := func(code int) {
+		resp.StatusCode = code
+		resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	}
+	if !ok {
+		LogIfVerbose("%s %s is not in the bundle\n", req.Method, req.URL)
+		setStatus(http.StatusNotFound)
+		return resp, nil
+	}
+	setStatus(recorded.Status)
+	if len(recorded.ContentType) > 0 {
+		resp.Header.Set("Content-Type", recorded.ContentType)
+	}
+	if len(recorded.Location) > 0 {
+		resp.Header.Set("Location", recorded.Location)
+	}
+	if len(recorded.File) == 0 {
+		return resp, nil
+	}
+
+	f, err := os.Open(filepath.Join(b.dir, filepath.FromSlash(recorded.File)))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := fi.Size()
+	resp.Header.Set("Accept-Ranges", "bytes")
+	resp.ContentLength = size
+	// Resumed downloads ask for the rest of the file with "bytes=<start>-".
+	if rng := req.Header.Get("Range"); recorded.Status == http.StatusOK && strings.HasPrefix(rng, "bytes=") && strings.HasSuffix(rng, "-") {
+		start, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"), 10, 64)
+		if err == nil && start >= 0 && start < size {
+			if _, err := f.Seek(start, io.SeekStart); err != nil {
+				f.Close()
+				return nil, err
+			}
+			setStatus(http.StatusPartialContent)
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, size-1, size))
+			resp.ContentLength = size - start
+		}
+	}
+	resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	if req.Method == http.MethodHead {
+		f.Close()
+		return resp, nil
+	}
+	resp.Body = f
+	return resp, nil
+}
+
+// OpenBundle extracts the bundle at file to a temporary folder, removed on
+// exit, and answers every request from it from now on.
+func OpenBundle(file string) (error, BundleManifest) {
+	var manifest BundleManifest
+	dir, err := os.MkdirTemp("", "modpacksch-bundle-")
+	if err != nil {
+		return err, manifest
+	}
+	atExit(func() { os.RemoveAll(dir) })
+
+	if err := extractBundle(file, dir); err != nil {
+		return err, manifest
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, bundleManifestName))
+	if err != nil {
+		return fmt.Errorf("not a bundle, no %s: %w", bundleManifestName, err), manifest
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return fmt.Errorf("invalid %s: %w", bundleManifestName, err), manifest
+	}
+	if manifest.Format > bundleFormat {
+		return fmt.Errorf("bundle format %d is newer than this downloader supports, please update it", manifest.Format), manifest
+	}
+
+	replay := &bundleReplay{dir, make(map[string]bundleResponse)}
+	for _, recorded := range manifest.Responses {
+		replay.responses[bundleKey(recorded.Method, recorded.URL)] = recorded
+	}
+	client.Transport = replay
+	return nil, manifest
+}
+
+func extractBundle(file string, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	in := bufio.NewReader(f)
+	var r io.Reader = in
+	magic, _ := in.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("bundle entry %s is outside the bundle", hdr.Name)
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// UseBundle sets the options a bundle was made with that change what is
+// requested, so an install asks for what is in it.
+func UseBundle(manifest BundleManifest) {
+	Options.Curseforge = manifest.Curseforge
+	Options.Mirrors = manifest.Mirrors.All
+	Options.Forgemirrors = manifest.Mirrors.Forge
+	Options.Neoforgemirrors = manifest.Mirrors.NeoForge
+	Options.Fabricmirrors = manifest.Mirrors.Fabric
+	Options.Mojangmirrors = manifest.Mirrors.Mojang
+	Options.Mirrorsonly = manifest.Mirrors.Only
+	if !manifest.Java {
+		Options.Nojava = true
+	} else if !Options.Nojava && (manifest.OS != runtime.GOOS || manifest.Arch != runtime.GOARCH) {
+		if !QuestionYN(false, "The bundle's Java is for %s/%s and won't run on %s/%s. Do you wish to continue without it?", manifest.OS, manifest.Arch, runtime.GOOS, runtime.GOARCH) {
+			fatalf("Aborted by user")
+		}
+		Options.Nojava = true
+	}
+}
+
+// openFromBundle opens the bundle in --from-bundle and returns the pack and
+// version to install from it. packId and versionId are from the arguments and
+// must match the bundle if given.
+func openFromBundle(packId int, versionId int) (int, int) {
+	err, manifest := OpenBundle(Options.Frombundle)
+	if err != nil {
+		fatalf("Unable to open bundle %s: %v\n", Options.Frombundle, err)
+	}
+	if (packId > -1 && packId != manifest.Modpack.ID) || (versionId > -1 && versionId != manifest.Version.ID) {
+		fatalf("Bundle %s holds %s (%d) version %s (%d), not the requested one\n", Options.Frombundle, manifest.Modpack.Name, manifest.Modpack.ID, manifest.Version.Name, manifest.Version.ID)
+	}
+	printfln("Installing %s version %s from bundle %s made %s", manifest.Modpack.Name, manifest.Version.Name, Options.Frombundle, manifest.Created)
+	UseBundle(manifest)
+	return manifest.Modpack.ID, manifest.Version.ID
+}
+
+// bundleEntry is a file to write to a bundle, from data or the file at path.
+type bundleEntry struct {
+	name string
+	data []byte
+	path string
+}
+
+// bundleCompression is how a bundle written to file is compressed, from its
+// extension: gzip for .gz and .tgz, none for .tar and zstd for anything else.
+func bundleCompression(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".gz", ".tgz":
+		return "gzip"
+	case ".tar":
+		return "none"
+	}
+	return "zstd"
+}
+
+// WriteBundle writes manifest and entries as a tar archive at file, compressed
+// as bundleCompression says. It is written under a temporary name and
+// renamed, so file is never left half written.
+func WriteBundle(file string, manifest BundleManifest, entries []bundleEntry) error {
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	entries = append([]bundleEntry{{name: bundleManifestName, data: raw}}, entries...)
+
+	tmp := file + partSuffix
+	atExit(func() { os.Remove(tmp) })
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var compressed io.WriteCloser
+	switch bundleCompression(file) {
+	case "gzip":
+		compressed = gzip.NewWriter(f)
+	case "zstd":
+		compressed, err = zstd.NewWriter(f)
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	var w io.Writer = f
+	if compressed != nil {
+		w = compressed
+	}
+	tw := tar.NewWriter(w)
+	err = writeBundleEntries(tw, entries)
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
+	}
+	if compressed != nil {
+		if closeErr := compressed.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func writeBundleEntries(tw *tar.Writer, entries []bundleEntry) error {
+	now := time.Now()
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, ModTime: now, Typeflag: tar.TypeReg}
+		if len(entry.path) == 0 {
+			hdr.Size = int64(len(entry.data))
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(entry.data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeBundleFile(tw, hdr, entry.path); err != nil {
+			return fmt.Errorf("error adding %s: %w", entry.path, err)
+		}
+	}
+	return nil
+}
+
+func writeBundleFile(tw *tar.Writer, hdr *tar.Header, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr.Size = fi.Size()
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// bundleEntries adds the responses recorder kept and the downloads, fetched
+// into stage, to manifest, and lists the files to write to the bundle for
+// them.
+func bundleEntries(manifest *BundleManifest, recorder *bundleRecorder, downloads []Download, stage string) []bundleEntry {
+	var entries []bundleEntry
+	// Downloads fetched whilst planning, such as the Forge installer, are
+	// served from the files below instead of a second copy.
+	downloaded := make(map[string]bool)
+	for _, down := range downloads {
+		for _, u := range append([]url.URL{down.URL}, down.Mirrors...) {
+			downloaded[bundleKey(http.MethodGet, bundleURL(u.String()))] = true
+		}
+	}
+	keys := make([]string, 0, len(recorder.responses))
+	for key := range recorder.responses {
+		if !downloaded[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		recorded := recorder.responses[key]
+		manifest.Responses = append(manifest.Responses, recorded)
+		if len(recorded.File) > 0 {
+			entries = append(entries, bundleEntry{name: recorded.File, data: recorder.bodies[recorded.File]})
+		}
+	}
+	// Each download is served for every URL it could be asked for at.
+	for i, down := range downloads {
+		name := fmt.Sprintf("files/%d/%s", i, down.Name)
+		entries = append(entries, bundleEntry{name: name, path: down.Filename(stage)})
+		for _, u := range append([]url.URL{down.URL}, down.Mirrors...) {
+			manifest.Responses = append(manifest.Responses, bundleResponse{Method: http.MethodGet, URL: bundleURL(u.String()), Status: http.StatusOK, File: name})
+		}
+	}
+	return entries
+}
+
+func runBundle(ctx context.Context, filename string, args []string) {
+	err, packId, versionId := parseIds(args)
+	if err == nil && packId == -1 {
+		err = errors.New("missing modpack id")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		PrintCommandUsage(filename, findCommand("bundle"))
+		os.Exit(2)
+	}
+	if versionId == -1 || Options.Latest {
+		versionId = -2
+	}
+
+	printHeader()
+
+	recorder := &bundleRecorder{responses: make(map[string]bundleResponse), bodies: make(map[string][]byte)}
+	client.Transport = recordingTransport{client.Transport, recorder}
+
+	// The install is worked out and downloaded into a folder next to the
+	// bundle, as if it were a new install there.
+	outDir := "."
+	if len(Options.Bundlefile) > 0 {
+		outDir = filepath.Dir(Options.Bundlefile)
+	}
+	stage, err := os.MkdirTemp(outDir, ".bundle-")
+	if err != nil {
+		fatalf("Unable to create a folder to download into: %v\n", err)
+	}
+	atExit(func() { os.RemoveAll(stage) })
+
+	err, plan := BuildPlan(ctx, packId, versionId, stage)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	recorder.stop()
+
+	out := Options.Bundlefile
+	if len(out) == 0 {
+		out = fmt.Sprintf("bundle_%d_%d.tar.zst", plan.Modpack.ID, plan.VersionInfo.ID)
+	}
+
+	downloads = append(downloads, plan.Files.Downloads(true)...)
+	downloads = append(downloads, plan.ExtraDownloads...)
+	downloads = append(downloads, plan.ModLoaderDownloads...)
+	downloads = append(downloads, plan.JavaDownloads...)
+	printfln("Bundling %s version %s, %d files", plan.Modpack.Name, plan.VersionInfo.Name, len(downloads))
+	// Space for the downloads and the bundle made from them.
+	CheckDiskSpace(outDir, 2*RequiredSpace(downloads, nil))
+	downloadAll(ctx, stage)
+	if failed > 0 {
+		fatalf("Unable to bundle %s: %d downloads failed\n", out, failed)
+	}
+
+	manifest := BundleManifest{
+		Format:     bundleFormat,
+		Created:    time.Now().UTC().Format(time.RFC3339),
+		Downloader: verStr,
+		Modpack:    planVersion{ID: plan.Modpack.ID, Name: plan.Modpack.Name},
+		Version:    planVersion{plan.VersionInfo.ID, plan.VersionInfo.Name, plan.VersionInfo.Type},
+		Targets:    plan.VersionInfo.Targets,
+		Curseforge: Options.Curseforge,
+		Java:       len(plan.JavaDownloads) > 0,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Files:      toPlanFiles(downloads),
+	}
+	manifest.Mirrors.All = Options.Mirrors
+	manifest.Mirrors.Forge = Options.Forgemirrors
+	manifest.Mirrors.NeoForge = Options.Neoforgemirrors
+	manifest.Mirrors.Fabric = Options.Fabricmirrors
+	manifest.Mirrors.Mojang = Options.Mojangmirrors
+	manifest.Mirrors.Only = Options.Mirrorsonly
+
+	var entries []bundleEntry
+	if raw := recorder.body(versionURL(plan.Modpack.ID, plan.VersionInfo.ID)); len(raw) > 0 {
+		entries = append(entries, bundleEntry{name: bundleVersionName, data: raw})
+	}
+	entries = append(entries, bundleEntries(&manifest, recorder, downloads, stage)...)
+
+	emitPhase("bundle")
+	if err := WriteBundle(out, manifest, entries); err != nil {
+		fatalf("Unable to write bundle %s: %v\n", out, err)
+	}
+	printfln("Wrote %s", out)
+	exit(0, "")
+}
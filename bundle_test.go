@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeNetwork serves bodies by URL, and counts the requests it answers.
+type fakeNetwork struct {
+	mu       sync.Mutex
+	bodies   map[string][]byte
+	requests int
+}
+
+func (n *fakeNetwork) RoundTrip(req *http.Request) (*http.Response, error) {
+	n.mu.Lock()
+	n.requests++
+	body, ok := n.bodies[req.URL.String()]
+	n.mu.Unlock()
+	resp := &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header), Body: http.NoBody, Request: req}
+	if !ok {
+		return resp, nil
+	}
+	resp.StatusCode = http.StatusOK
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if req.Method != http.MethodHead {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return resp, nil
+}
+
+func zipOf(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func libraryJson(name string) string {
+	return fmt.Sprintf(`{"libraries": [{"name": "%[1]s", "downloads": {"artifact": {"path": "test/%[1]s/1/%[1]s-1.jar", "url": "%[2]stest/%[1]s/1/%[1]s-1.jar"}}}]}`, name, forgeMaven)
+}
+
+func fullPaths(downloads []Download) []string {
+	ret := []string{}
+	for _, download := range downloads {
+		ret = append(ret, filepath.ToSlash(download.FullPath))
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// TestForgeBundleOffline bundles a Forge install whose version JSON is on the
+// Maven, then works out and fetches the install again from the bundle alone.
+func TestForgeBundleOffline(t *testing.T) {
+	savedTransport := client.Transport
+	defer func() { client.Transport = savedTransport }()
+
+	forge := ForgeInstall{ForgeVersion{RawVersion: "47.1.0", Minecraft: Minecraft{RawVersion: "1.20.1"}}}
+	versionStr := "1.20.1-47.1.0"
+	network := &fakeNetwork{bodies: map[string][]byte{
+		mavenURL("forge", fmt.Sprintf(forgeUrlInstallJSON, versionStr, versionStr)): []byte(libraryJson("forge-lib")),
+		mavenURL("forge", fmt.Sprintf(forgeUrlInstallJar, versionStr, "forge-"+versionStr+"-installer.jar")): zipOf(t, map[string]string{
+			"install_profile.json": libraryJson("processor-lib"),
+		}),
+		forgeMaven + "test/forge-lib/1/forge-lib-1.jar":         []byte("forge library"),
+		forgeMaven + "test/processor-lib/1/processor-lib-1.jar": []byte("processor library"),
+		MinecraftMetaURL:                  []byte(`{"versions": [{"id": "1.20.1", "url": "https://example.com/1.20.1.json"}]}`),
+		"https://example.com/1.20.1.json": []byte(`{"downloads": {"server": {"url": "https://example.com/server.jar"}}}`),
+		"https://example.com/server.jar":  []byte("vanilla server"),
+	}}
+	recorder := &bundleRecorder{responses: make(map[string]bundleResponse), bodies: make(map[string][]byte)}
+	client.Transport = recordingTransport{network, recorder}
+
+	// Bundles are planned in a new, empty folder.
+	stage := t.TempDir()
+	ctx := context.Background()
+	bundled := forge.GetDownloads(ctx, stage)
+	recorder.stop()
+	want := []string{"forge-1.20.1-47.1.0-installer.jar.jar", "libraries/test/forge-lib/1/forge-lib-1.jar", "libraries/test/processor-lib/1/processor-lib-1.jar", "minecraft_server.1.20.1.jar"}
+	if got := fullPaths(bundled); !reflect.DeepEqual(got, want) {
+		t.Fatalf("downloads = %v, want %v", got, want)
+	}
+	for _, download := range bundled {
+		resp, err := httpGet(ctx, download.URL.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := download.Filename(stage)
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		if err := os.WriteFile(filename, body, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := BundleManifest{Format: bundleFormat}
+	entries := bundleEntries(&manifest, recorder, bundled, stage)
+	file := filepath.Join(t.TempDir(), "bundle.tar.zst")
+	if err := WriteBundle(file, manifest, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	network.requests = 0
+	if err, _ := OpenBundle(file); err != nil {
+		t.Fatal(err)
+	}
+	installPath := t.TempDir()
+	replayed := forge.GetDownloads(ctx, installPath)
+	if got := fullPaths(replayed); !reflect.DeepEqual(got, want) {
+		t.Fatalf("downloads from the bundle = %v, want %v", got, want)
+	}
+	for _, download := range replayed {
+		resp, err := httpGet(ctx, download.URL.String())
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s is not in the bundle: %v", download.FullPath, err)
+		}
+		resp.Body.Close()
+	}
+	if network.requests > 0 {
+		t.Errorf("installing from the bundle made %d network requests", network.requests)
+	}
+}
+
+func TestBundleCompression(t *testing.T) {
+	for _, name := range []string{"bundle.tar.zst", "bundle.tar.gz", "bundle.tgz", "bundle.tar"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), name)
+			manifest := BundleManifest{Format: bundleFormat, Modpack: planVersion{ID: 1, Name: "pack"}}
+			if err := WriteBundle(file, manifest, []bundleEntry{{name: "files/0/a.txt", data: []byte("a")}}); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := extractBundle(file, dir); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "files", "0", "a.txt"))
+			if err != nil || string(data) != "a" {
+				t.Errorf("a.txt = %q, %v", data, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, bundleManifestName)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
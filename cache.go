@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// linkedExtensions are files that are hardlinked between the cache and
+// installs. Anything else, such as configs, is copied so editing it in one
+// install can't change the cache or other installs.
+var linkedExtensions = []string{".jar", ".zip", ".gz", ".tgz", ".litemod"}
+
+// CacheDir is where downloads are kept by hash, shared between installs.
+func CacheDir() (error, string) {
+	if len(Options.Cachedir) > 0 {
+		return nil, Options.Cachedir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return err, ""
+	}
+	return nil, filepath.Join(dir, "modpacksch")
+}
+
+// cachePath is where download is kept in the cache, if it can be cached.
+func cachePath(download Download) (string, bool) {
+	if Options.Nocache || (download.HashType != "sha1" && download.HashType != "sha256") {
+		return "", false
+	}
+	hash := strings.ToLower(download.Hash)
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) < 3 {
+		return "", false
+	}
+	err, dir := CacheDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(dir, download.HashType, hash[:2], hash), true
+}
+
+func linkable(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, linked := range linkedExtensions {
+		if ext == linked {
+			return true
+		}
+	}
+	return false
+}
+
+// placeFile puts a copy of src at dst, by hardlink if allowed and possible.
+// dst is written under a temporary name and renamed, so it is never left
+// half written.
+func placeFile(src string, dst string, link bool) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + partSuffix
+	os.Remove(tmp)
+	if !link || os.Link(src, tmp) != nil {
+		if err := copyFile(src, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fromCache puts download at filename from the cache. A cache entry that
+// doesn't match its hash is removed.
+func fromCache(download Download, filename string) bool {
+	path, ok := cachePath(download)
+	if !ok {
+		return false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	cached := download
+	cached.Path = filepath.Dir(path)
+	cached.Name = filepath.Base(path)
+	if !cached.VerifyChecksum("") {
+		LogIfVerbose("Removing corrupt cache entry %s\n", path)
+		os.Remove(path)
+		return false
+	}
+	if err := placeFile(path, filename, linkable(filename)); err != nil {
+		LogIfVerbose("Unable to use cache entry %s: %v\n", path, err)
+		return false
+	}
+	// The modification time records when an entry was last used, for pruning.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true
+}
+
+// toCache keeps a verified download in the cache for other installs.
+func toCache(download Download, filename string) {
+	path, ok := cachePath(download)
+	if !ok {
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	if err := placeFile(filename, path, linkable(filename)); err != nil {
+		LogIfVerbose("Unable to cache %s: %v\n", filename, err)
+	}
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func cacheEntries(dir string) ([]cacheEntry, error) {
+	var entries []cacheEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, cacheEntry{path, info.Size(), info.ModTime()})
+		return nil
+	})
+	return entries, err
+}
+
+// parseSize reads a size in bytes with an optional K, M, G or T suffix.
+func parseSize(input string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(input), "B"))
+	multiplier := int64(1)
+	if len(value) > 0 {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size \"%s\"", input)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// parseAge reads a duration, also accepting a number of days such as "30d".
+func parseAge(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age \"%s\"", value)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age \"%s\"", value)
+	}
+	return age, nil
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+type cacheStats struct {
+	Path   string `json:"path"`
+	Files  int    `json:"files"`
+	Size   int64  `json:"size"`
+	Oldest string `json:"oldest,omitempty"`
+	Newest string `json:"newest,omitempty"`
+}
+
+type cachePruneResult struct {
+	Path    string `json:"path"`
+	Removed int    `json:"removed"`
+	Freed   int64  `json:"freed"`
+	Files   int    `json:"files"`
+	Size    int64  `json:"size"`
+}
+
+// PruneCache removes entries unused for longer than maxAge, then the least
+// recently used entries until the cache fits in maxSize. A negative maxSize or
+// a zero maxAge is no limit.
+func PruneCache(dir string, maxSize int64, maxAge time.Duration) (error, cachePruneResult) {
+	result := cachePruneResult{Path: dir}
+	entries, err := cacheEntries(dir)
+	if err != nil {
+		return err, result
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	for _, entry := range entries {
+		expired := maxAge > 0 && time.Since(entry.modTime) > maxAge
+		// Left over from an interrupted copy into the cache.
+		partial := strings.HasSuffix(entry.path, partSuffix)
+		if !expired && !partial && (maxSize < 0 || total <= maxSize) {
+			result.Files++
+			result.Size += entry.size
+			continue
+		}
+		LogIfVerbose("Removing %s\n", entry.path)
+		if err := os.Remove(entry.path); err != nil {
+			printfln("Error occurred whilst removing %s: %v", entry.path, err)
+			result.Files++
+			result.Size += entry.size
+			continue
+		}
+		total -= entry.size
+		result.Removed++
+		result.Freed += entry.size
+	}
+	return nil, result
+}
+
+func runCache(ctx context.Context, filename string, args []string) {
+	if len(args) != 1 || (args[0] != "stats" && args[0] != "prune") {
+		fmt.Fprintf(os.Stderr, "Expected \"cache stats\" or \"cache prune\"\n\n")
+		PrintCommandUsage(filename, findCommand("cache"))
+		os.Exit(2)
+	}
+	if Options.Output == "json" {
+		loggerOut = os.Stderr
+	}
+
+	err, dir := CacheDir()
+	if err != nil {
+		fatalf("Unable to find the cache folder: %v\n", err)
+	}
+
+	var out interface{}
+	if args[0] == "stats" {
+		entries, err := cacheEntries(dir)
+		if err != nil {
+			fatalf("Unable to read cache at %s: %v\n", dir, err)
+		}
+		stats := cacheStats{Path: dir}
+		var oldest, newest time.Time
+		for _, entry := range entries {
+			stats.Files++
+			stats.Size += entry.size
+			if oldest.IsZero() || entry.modTime.Before(oldest) {
+				oldest = entry.modTime
+			}
+			if entry.modTime.After(newest) {
+				newest = entry.modTime
+			}
+		}
+		if stats.Files > 0 {
+			stats.Oldest = oldest.UTC().Format(time.RFC3339)
+			stats.Newest = newest.UTC().Format(time.RFC3339)
+		}
+		if Options.Output != "json" {
+			fmt.Printf("Cache at %s holds %d files, %s\n", stats.Path, stats.Files, formatSize(stats.Size))
+			if stats.Files > 0 {
+				fmt.Printf("Least recently used %s, most recently used %s\n", stats.Oldest, stats.Newest)
+			}
+			return
+		}
+		out = stats
+	} else {
+		maxSize := int64(-1)
+		var maxAge time.Duration
+		if len(Options.Maxsize) > 0 {
+			if maxSize, err = parseSize(Options.Maxsize); err != nil {
+				fatalf("Invalid --max-size: %v\n", err)
+			}
+		}
+		if len(Options.Maxage) > 0 {
+			if maxAge, err = parseAge(Options.Maxage); err != nil {
+				fatalf("Invalid --max-age: %v\n", err)
+			}
+		}
+		if len(Options.Maxsize) == 0 && len(Options.Maxage) == 0 {
+			if !QuestionYN(false, "No --max-size or --max-age given, this will empty the cache at %s. Do you wish to continue?", dir) {
+				fatalf("Aborted by user")
+			}
+			maxSize = 0
+		}
+		err, result := PruneCache(dir, maxSize, maxAge)
+		if err != nil {
+			fatalf("Unable to prune cache at %s: %v\n", dir, err)
+		}
+		if Options.Output != "json" {
+			fmt.Printf("Removed %d files, freeing %s. %d files, %s left in %s\n", result.Removed, formatSize(result.Freed), result.Files, formatSize(result.Size), result.Path)
+			return
+		}
+		out = result
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+}
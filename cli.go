@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Command is a subcommand of the installer. Options fields are bound as flags
+// to every command listed in their cmd tag, or to all commands if it is empty.
+type Command struct {
+	Name    string
+	Args    string
+	Summary string
+	Run     func(ctx context.Context, filename string, args []string)
+}
+
+var commands []*Command
+
+func init() {
+	commands = []*Command{
+		{"install", "[<modpackid> [<versionid>]]", "Install a modpack server. This is the default when no command is given.", runInstall},
+		{"update", "[<modpackid> [<versionid>]]", "Update an existing install, by default to the latest version of the installed pack. Paths matching the patterns in .serverdownloaderignore are left as they are.", runUpdate},
+		{"verify", "", "Check the files of an existing install against its version.json. Exits with 3 if anything differs.", runVerify},
+		{"repair", "", "Download missing or modified files of an existing install again.", runRepair},
+		{"uninstall", "", "Remove the files installed by the pack, keeping worlds, server settings and anything you added.", runUninstall},
+		{"clean", "", "Same as uninstall.", runUninstall},
+		{"rollback", "", "Restore the files of an install from the snapshot taken before its last update.", runRollback},
+		{"info", "<modpackid> [<versionid>]", "Show the versions of a modpack and the targets of the selected or latest version.", runInfo},
+		{"search", "<term>", "Search for modpacks and show their IDs and latest versions.", runSearch},
+		{"bundle", "<modpackid> [<versionid>]", "Download everything needed to install a modpack version into one file, for installing with --from-bundle where there is no network access.", runBundle},
+		{"cache", "stats|prune", "Show the size of the download cache, or remove old files from it with --max-size and --max-age.", runCache},
+		{"config", "show", "Show the effective value of every option and where it came from.", runConfig},
+		{"help", "[<command>]", "Show help for a command.", runHelp},
+	}
+}
+
+func findCommand(name string) *Command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// RunCommand picks the subcommand from args, parses its flags into Options and
+// runs it. Anything that is not a known command falls through to install, so
+// "<modpackid> <versionid>" and filename-derived installs keep working.
+func RunCommand(filename string, args []string) {
+	cmd := findCommand("install")
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if found := findCommand(args[0]); found != nil {
+			cmd = found
+			args = args[1:]
+		} else if _, err := strconv.Atoi(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Unknown command \"%s\"\n\n", args[0])
+			PrintUsage(filename)
+			os.Exit(2)
+		}
+	}
+
+	positional, setByFlag, err := parseFlags(cmd.Name, args)
+	// Help doesn't depend on the config or the other options being valid.
+	if err == nil && Options.Help {
+		if cmd.Name == "install" && len(args) == 0 {
+			PrintUsage(filename)
+		} else {
+			PrintCommandUsage(filename, cmd)
+		}
+		os.Exit(0)
+	}
+	if err == nil {
+		err = LoadConfig(setByFlag)
+	}
+	if err == nil {
+		err = validateOptions()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		PrintCommandUsage(filename, cmd)
+		os.Exit(2)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	cmd.Run(ctx, filename, positional)
+}
+
+func optionAppliesTo(field reflect.StructField, command string) bool {
+	cmds := field.Tag.Get("cmd")
+	if len(cmds) == 0 {
+		return true
+	}
+	for _, cmd := range strings.Split(cmds, ",") {
+		if cmd == command {
+			return true
+		}
+	}
+	return false
+}
+
+func optionNames(field reflect.StructField) []string {
+	names := strings.Split(field.Tag.Get("flag"), ",")
+	if short := field.Tag.Get("short"); len(short) > 0 {
+		names = append(names, short)
+	}
+	return names
+}
+
+func bindOptions(fs *flag.FlagSet, command string) {
+	v := reflect.ValueOf(&Options).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.Tag.Get("flag")) == 0 || !optionAppliesTo(field, command) {
+			continue
+		}
+		help := field.Tag.Get("help")
+		for _, name := range optionNames(field) {
+			switch ptr := v.Field(i).Addr().Interface().(type) {
+			case *bool:
+				fs.BoolVar(ptr, name, *ptr, help)
+			case *string:
+				fs.StringVar(ptr, name, *ptr, help)
+			case *int:
+				fs.IntVar(ptr, name, *ptr, help)
+			case *int64:
+				fs.Int64Var(ptr, name, *ptr, help)
+			case *[]string:
+				fs.Var((*stringList)(ptr), name, help)
+			}
+		}
+	}
+}
+
+// parseFlags parses the flags for command into Options and returns the
+// positional arguments and the config names of the options that were set.
+// Flags and positional arguments may be mixed.
+func parseFlags(command string, args []string) ([]string, map[string]bool, error) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	bindOptions(fs, command)
+
+	var positional []string
+	args, rest := splitTerminator(fs, args)
+	args = joinBoolValues(fs, args)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	positional = append(positional, rest...)
+
+	keys := make(map[string]string)
+	t := reflect.TypeOf(Options)
+	for i := 0; i < t.NumField(); i++ {
+		for _, name := range optionNames(t.Field(i)) {
+			keys[name] = optionKey(t.Field(i))
+		}
+	}
+	setByFlag := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setByFlag[keys[f.Name]] = true
+	})
+	return positional, setByFlag, nil
+}
+
+// splitTerminator splits args at the "--" ending the flags, if there is one.
+// Everything after it is positional, even if it starts with a dash.
+func splitTerminator(fs *flag.FlagSet, args []string) ([]string, []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+		name := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") || strings.Contains(name, "=") {
+			continue
+		}
+		if f := fs.Lookup(name); f != nil {
+			// Skip the value of a flag, which may itself be "--".
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+			}
+		}
+	}
+	return args, nil
+}
+
+// joinBoolValues rewrites "--flag true" and "--flag false" to "--flag=true"
+// and "--flag=false" for bool flags, as the old parser accepted both forms.
+func joinBoolValues(fs *flag.FlagSet, args []string) []string {
+	ret := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name := strings.TrimLeft(arg, "-")
+		if strings.HasPrefix(arg, "-") && !strings.Contains(name, "=") && i+1 < len(args) {
+			if f := fs.Lookup(name); f != nil {
+				if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+					if next := args[i+1]; next == "true" || next == "false" {
+						ret = append(ret, arg+"="+next)
+						i++
+						continue
+					}
+				}
+			}
+		}
+		ret = append(ret, arg)
+	}
+	return ret
+}
+
+func validateOptions() error {
+	if Options.Threads < 1 {
+		return fmt.Errorf("invalid value %d for --threads: must be at least 1", Options.Threads)
+	}
+	if Options.Retries < 0 {
+		return fmt.Errorf("invalid value %d for --retries: must not be negative", Options.Retries)
+	}
+	if Options.Maxperhost < 0 {
+		return fmt.Errorf("invalid value %d for --max-per-host: must not be negative", Options.Maxperhost)
+	}
+	if len(Options.Limitrate) > 0 {
+		rate, err := parseSize(Options.Limitrate)
+		if err != nil || rate < 1 {
+			return fmt.Errorf("invalid value \"%s\" for --limit-rate: must be a number of bytes per second, e.g. 500K or 2M", Options.Limitrate)
+		}
+		limiter = newRateLimiter(rate)
+	}
+	if Options.Timeout < 0 {
+		return fmt.Errorf("invalid value %d for --timeout: must not be negative", Options.Timeout)
+	}
+	if err := configureTransport(); err != nil {
+		return err
+	}
+	if Options.Limit < 1 {
+		return fmt.Errorf("invalid value %d for --limit: must be at least 1", Options.Limit)
+	}
+	if _, ok := channelRank[Options.Channel]; !ok {
+		return fmt.Errorf("invalid value \"%s\" for --channel: must be release, beta or alpha", Options.Channel)
+	}
+	if Options.Output != "text" && Options.Output != "json" {
+		return fmt.Errorf("invalid value \"%s\" for --output: must be text or json", Options.Output)
+	}
+	if Options.Mergeconflicts != "markers" && Options.Mergeconflicts != "rej" {
+		return fmt.Errorf("invalid value \"%s\" for --merge-conflicts: must be markers or rej", Options.Mergeconflicts)
+	}
+	return nil
+}
+
+// parseIds reads the optional <modpackid> <versionid> positional arguments.
+// Missing values are returned as -1.
+func parseIds(args []string) (error, int, int) {
+	packId, versionId := -1, -1
+	if len(args) > 2 {
+		return errors.New("too many arguments: " + strings.Join(args[2:], " ")), packId, versionId
+	}
+	if len(args) > 0 {
+		id, err := strconv.Atoi(args[0])
+		if err != nil || id < 0 {
+			return errors.New("invalid modpack id: " + args[0]), packId, versionId
+		}
+		packId = id
+	}
+	if len(args) > 1 {
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id < 0 {
+			return errors.New("invalid version id: " + args[1]), packId, versionId
+		}
+		versionId = id
+	}
+	return nil, packId, versionId
+}
+
+func runInstall(ctx context.Context, filename string, args []string) {
+	err, packIdFound, versionFound := parseIds(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		PrintCommandUsage(filename, findCommand("install"))
+		os.Exit(2)
+	}
+	if packIdFound > -1 && versionFound == -1 {
+		versionFound = -2
+	}
+
+	if Options.Latest {
+		versionFound = -2
+	}
+
+	printHeader()
+	if len(Options.Frombundle) > 0 {
+		packIdFound, versionFound = openFromBundle(packIdFound, versionFound)
+	}
+	HandleLaunch(ctx, filename, packIdFound, versionFound)
+}
+
+func runUpdate(ctx context.Context, filename string, args []string) {
+	err, packIdFound, versionFound := parseIds(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		PrintCommandUsage(filename, findCommand("update"))
+		os.Exit(2)
+	}
+
+	printHeader()
+
+	installPath := Options.Path
+	if len(installPath) == 0 {
+		installPath = "."
+	}
+	err, info := GetVersionInfoFromFile(filepath.Join(installPath, "version.json"))
+	if err != nil {
+		fatalf("No existing install found at %s: %v\n", installPath, err)
+	}
+	Options.Path = installPath
+
+	if packIdFound == -1 {
+		packIdFound = info.ParentId
+	}
+	if versionFound == -1 || Options.Latest {
+		versionFound = -2
+	}
+	if len(Options.Frombundle) > 0 {
+		packIdFound, versionFound = openFromBundle(packIdFound, -1)
+	}
+
+	HandleLaunch(ctx, filename, packIdFound, versionFound)
+}
+
+func runHelp(ctx context.Context, filename string, args []string) {
+	if len(args) > 0 {
+		if cmd := findCommand(args[0]); cmd != nil {
+			PrintCommandUsage(filename, cmd)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Unknown command \"%s\"\n\n", args[0])
+	}
+	PrintUsage(filename)
+}
+
+func PrintUsage(filename string) {
+	err, modpackID, versionID := ParseFilename(filename)
+
+	println("                      _                  _              _     ")
+	println("                     | |                | |            | |    ")
+	println("  _ __ ___   ___   __| |_ __   __ _  ___| | _____   ___| |__  ")
+	println(" | '_ ` _ \\ / _ \\ / _` | '_ \\ / _` |/ __| |/ / __| / __| '_ \\ ")
+	println(" | | | | | | (_) | (_| | |_) | (_| | (__|   <\\__ \\| (__| | | |")
+	println(" |_| |_| |_|\\___/ \\__,_| .__/ \\__,_|\\___|_|\\_\\___(_)___|_| |_|")
+	println("                       | |                                    ")
+	println("                       |_|                                    ")
+	println(" modpacks.ch server downloader golang - build " + verStr)
+	println(" based on commit " + commitStr)
+	println()
+	println("Usage:")
+	if err == nil {
+		fmt.Printf("  "+filename+" - without arguments will install modpack ID %d with version %d\n", modpackID, versionID)
+		fmt.Printf("  "+filename+" --latest - will install modpack ID %d with the latest version available\n", modpackID)
+	}
+	println("  " + filename + " <modpackid> <versionid> - will install the modpack specified by <modpackid> with the version specified by <versionid>")
+	println("  " + filename + " <modpackid> - will install the modpack specified by <modpackid> with the latest version available")
+	println("  " + filename + " <command> [arguments] - run one of the commands below")
+	println()
+	println("Commands:")
+	for _, cmd := range commands {
+		println(fmt.Sprintf("  %-10s %s", cmd.Name, cmd.Summary))
+	}
+	println()
+	println("Run \"" + filename + " help <command>\" for the arguments of a command.")
+}
+
+func PrintCommandUsage(filename string, cmd *Command) {
+	fmt.Printf("Usage: %s %s [arguments] %s\n\n", filename, cmd.Name, cmd.Args)
+	fmt.Println(cmd.Summary)
+	fmt.Println()
+	fmt.Println("Arguments:")
+
+	t := reflect.TypeOf(Options)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.Tag.Get("flag")) == 0 || !optionAppliesTo(field, cmd.Name) {
+			continue
+		}
+		var names []string
+		if short := field.Tag.Get("short"); len(short) > 0 {
+			names = append(names, "-"+short)
+		}
+		for _, name := range strings.Split(field.Tag.Get("flag"), ",") {
+			names = append(names, "--"+name)
+		}
+		valueStr := ""
+		switch field.Type.Kind() {
+		case reflect.Bool:
+		case reflect.Slice:
+			valueStr = " <list>"
+		default:
+			valueStr = " <" + field.Type.Kind().String() + ">"
+		}
+		fmt.Printf("  %s%s\n      %s\n", strings.Join(names, ", "), valueStr, field.Tag.Get("help"))
+	}
+}
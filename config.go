@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const ConfigFileName = "serverdownloader.toml"
+const EnvPrefix = "MODPACKSCH_"
+
+// optionSources records where the effective value of each option came from,
+// keyed by the option's config name.
+var optionSources = make(map[string]string)
+
+// stringList is a flag.Value for comma separated, repeatable list options.
+type stringList []string
+
+func (s *stringList) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(val string) error {
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			*s = append(*s, item)
+		}
+	}
+	return nil
+}
+
+// optionKey is the name of an option in config files and, upper cased with
+// EnvPrefix, in the environment.
+func optionKey(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("flag"), ",")[0]
+}
+
+func optionEnv(field reflect.StructField) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(optionKey(field), "-", "_"))
+}
+
+func setOption(v reflect.Value, raw interface{}) error {
+	switch ptr := v.Addr().Interface().(type) {
+	case *bool:
+		switch val := raw.(type) {
+		case bool:
+			*ptr = val
+		case string:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return err
+			}
+			*ptr = b
+		default:
+			return fmt.Errorf("expected a boolean, got %v", raw)
+		}
+	case *string:
+		val, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %v", raw)
+		}
+		*ptr = val
+	case *int, *int64:
+		var i int64
+		switch val := raw.(type) {
+		case int64:
+			i = val
+		case string:
+			parsed, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return err
+			}
+			i = parsed
+		default:
+			return fmt.Errorf("expected a number, got %v", raw)
+		}
+		v.SetInt(i)
+	case *[]string:
+		var list stringList
+		switch val := raw.(type) {
+		case string:
+			list.Set(val)
+		case []interface{}:
+			for _, item := range val {
+				str, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("expected a list of strings, got %v", raw)
+				}
+				list = append(list, str)
+			}
+		default:
+			return fmt.Errorf("expected a list of strings, got %v", raw)
+		}
+		*ptr = list
+	}
+	return nil
+}
+
+// LoadConfig fills in options that were not set by flags. The environment
+// takes precedence over config files, which take precedence over the defaults.
+// serverdownloader.toml in the install path overrides the one in
+// $XDG_CONFIG_HOME.
+func LoadConfig(setByFlag map[string]bool) error {
+	v := reflect.ValueOf(&Options).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		key := optionKey(t.Field(i))
+		if setByFlag[key] {
+			optionSources[key] = "flag"
+		} else {
+			optionSources[key] = "default"
+		}
+	}
+
+	var files []string
+	if !setByFlag["config"] {
+		if env, ok := os.LookupEnv(EnvPrefix + "CONFIG"); ok {
+			Options.Config = env
+			optionSources["config"] = "env " + EnvPrefix + "CONFIG"
+		}
+	}
+	if len(Options.Config) > 0 {
+		files = append(files, Options.Config)
+	} else {
+		if dir, err := os.UserConfigDir(); err == nil {
+			files = append(files, filepath.Join(dir, ConfigFileName))
+		}
+	}
+
+	apply := func(values map[string]interface{}, source string) error {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := optionKey(field)
+			if setByFlag[key] || field.Tag.Get("config") == "-" {
+				continue
+			}
+			raw, ok := values[key]
+			if !ok {
+				continue
+			}
+			if err := setOption(v.Field(i), raw); err != nil {
+				return fmt.Errorf("invalid value for %s in %s: %v", key, source, err)
+			}
+			optionSources[key] = source
+		}
+		return nil
+	}
+
+	env := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		if val, ok := os.LookupEnv(optionEnv(t.Field(i))); ok {
+			env[optionKey(t.Field(i))] = val
+		}
+	}
+
+	loadFile := func(file string, required bool) error {
+		values := make(map[string]interface{})
+		if _, err := toml.DecodeFile(file, &values); err != nil {
+			if os.IsNotExist(err) && !required {
+				return nil
+			}
+			return fmt.Errorf("error reading config file %s: %v", file, err)
+		}
+		LogIfVerbose("Read config file %s\n", file)
+		for key := range values {
+			if !isOptionKey(key) {
+				return fmt.Errorf("unknown option %s in config file %s", key, file)
+			}
+		}
+		return apply(values, "file "+file)
+	}
+
+	for _, file := range files {
+		if err := loadFile(file, len(Options.Config) > 0); err != nil {
+			return err
+		}
+	}
+
+	// The install path may only be known once the environment and the first
+	// file have been read.
+	if len(Options.Config) == 0 {
+		installPath := Options.Path
+		if val, ok := env["path"]; ok && !setByFlag["path"] {
+			installPath = val.(string)
+		}
+		if len(installPath) == 0 {
+			installPath = "."
+		}
+		if err := loadFile(filepath.Join(installPath, ConfigFileName), false); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := optionKey(field)
+		raw, ok := env[key]
+		if !ok || setByFlag[key] || field.Tag.Get("config") == "-" {
+			continue
+		}
+		if err := setOption(v.Field(i), raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", optionEnv(field), err)
+		}
+		optionSources[key] = "env " + optionEnv(field)
+	}
+
+	if len(Options.Apikey) > 0 {
+		apiKey = Options.Apikey
+	}
+
+	return nil
+}
+
+func isOptionKey(key string) bool {
+	t := reflect.TypeOf(Options)
+	for i := 0; i < t.NumField(); i++ {
+		if optionKey(t.Field(i)) == key && t.Field(i).Tag.Get("config") != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+type configValue struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+func runConfig(ctx context.Context, filename string, args []string) {
+	if len(args) != 1 || args[0] != "show" {
+		fmt.Fprintf(os.Stderr, "Expected \"config show\"\n\n")
+		PrintCommandUsage(filename, findCommand("config"))
+		os.Exit(2)
+	}
+
+	var values []configValue
+	v := reflect.ValueOf(Options)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("config") == "-" && field.Name != "Config" {
+			continue
+		}
+		key := optionKey(field)
+		value := v.Field(i).Interface()
+		if field.Name == "Apikey" && len(Options.Apikey) > 0 {
+			value = "(set)"
+		}
+		values = append(values, configValue{key, value, optionSources[key]})
+	}
+	sort.SliceStable(values, func(i, j int) bool { return values[i].Name < values[j].Name })
+
+	if Options.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(values)
+		return
+	}
+	for _, val := range values {
+		fmt.Printf("%-16s = %-30s (%s)\n", val.Name, fmt.Sprint(val.Value), val.Source)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DiskState is what an update diff knows about the files already installed.
+// Hashing is slow, so Matches is only asked when Exists is true and the answer
+// changes the plan.
+type DiskState interface {
+	// Exists reports whether there is a file at the path of download.
+	Exists(download Download) bool
+	// Matches reports whether that file has the hash of download.
+	Matches(download Download) bool
+}
+
+// installDir is the DiskState of an install folder.
+type installDir string
+
+func (dir installDir) Exists(download Download) bool {
+	_, err := os.Stat(filepath.Join(string(dir), download.FullPath))
+	return err == nil
+}
+
+func (dir installDir) Matches(download Download) bool {
+	return download.VerifyChecksum(string(dir))
+}
+
+// FileChange is a file listed by both the installed version and the new one.
+type FileChange struct {
+	Old Download
+	New Download
+}
+
+// Updated reports whether the new version has a different file.
+func (c FileChange) Updated() bool {
+	return c.Old.HashType != c.New.HashType || c.Old.Hash != c.New.Hash
+}
+
+// UpdateDiff is how the files of an install have to change to get from one
+// version of a pack to another. Every list is sorted by path.
+type UpdateDiff struct {
+	// Added are only in the new version.
+	Added []Download
+	// Changed are updated by the new version and untouched on disk.
+	Changed []FileChange
+	// Unchanged are the same in both versions, or already updated on disk.
+	Unchanged []Download
+	// Deleted are only in the installed version.
+	Deleted []Download
+	// LocallyModified no longer match the installed version on disk, usually
+	// because of manual config changes. Overwriting them loses those changes.
+	LocallyModified []FileChange
+	// Missing are in both versions but not on disk.
+	Missing []Download
+}
+
+// Downloads is every file the update fetches, with locally modified files only
+// if overwrite is true.
+func (d UpdateDiff) Downloads(overwrite bool) []Download {
+	var downloads []Download
+	for _, change := range d.Changed {
+		downloads = append(downloads, change.New)
+	}
+	if overwrite {
+		for _, change := range d.LocallyModified {
+			downloads = append(downloads, change.New)
+		}
+	}
+	downloads = append(downloads, d.Missing...)
+	downloads = append(downloads, d.Added...)
+	return downloads
+}
+
+// ModifiedUpdates are the locally modified files that the new version updates.
+func (d UpdateDiff) ModifiedUpdates() []FileChange {
+	var ret []FileChange
+	for _, change := range d.LocallyModified {
+		if change.Updated() {
+			ret = append(ret, change)
+		}
+	}
+	return ret
+}
+
+// ModifiedUnchanged are the locally modified files that the new version does
+// not update, only found when unchanged files are verified.
+func (d UpdateDiff) ModifiedUnchanged() []FileChange {
+	var ret []FileChange
+	for _, change := range d.LocallyModified {
+		if !change.Updated() {
+			ret = append(ret, change)
+		}
+	}
+	return ret
+}
+
+// byPath indexes downloads by their cleaned path. If a path is listed twice
+// the last one wins, as it would when downloading.
+func byPath(downloads []Download) (map[string]Download, []string) {
+	files := make(map[string]Download, len(downloads))
+	var paths []string
+	for _, download := range downloads {
+		path := filepath.Clean(download.FullPath)
+		if _, ok := files[path]; !ok {
+			paths = append(paths, path)
+		}
+		files[path] = download
+	}
+	sort.Strings(paths)
+	return files, paths
+}
+
+// DiffFiles works out how to update an install from the oldFiles of its
+// version to newFiles, given what is on disk. Files that neither version
+// changes are only hashed if verifyUnchanged is true.
+func DiffFiles(oldFiles []Download, newFiles []Download, disk DiskState, verifyUnchanged bool) UpdateDiff {
+	var diff UpdateDiff
+	oldByPath, oldPaths := byPath(oldFiles)
+	newByPath, newPaths := byPath(newFiles)
+
+	for _, path := range newPaths {
+		newDown := newByPath[path]
+		oldDown, ok := oldByPath[path]
+		if !ok {
+			LogIfVerbose("Found new file %s\n", path)
+			diff.Added = append(diff.Added, newDown)
+			continue
+		}
+		change := FileChange{oldDown, newDown}
+		if !disk.Exists(newDown) {
+			LogIfVerbose("Found missing file %s\n", path)
+			diff.Missing = append(diff.Missing, newDown)
+			continue
+		}
+		if !change.Updated() {
+			if verifyUnchanged {
+				LogIfVerbose("Checking integrity of file %s\n", path)
+				if !disk.Matches(newDown) {
+					LogIfVerbose("Detected failed checksum on %s\n", path)
+					diff.LocallyModified = append(diff.LocallyModified, change)
+					continue
+				}
+			}
+			diff.Unchanged = append(diff.Unchanged, newDown)
+			continue
+		}
+		if disk.Matches(oldDown) {
+			LogIfVerbose("Found changed file %s\n", path)
+			diff.Changed = append(diff.Changed, change)
+		} else if disk.Matches(newDown) {
+			LogIfVerbose("Found changed file %s, already updated\n", path)
+			diff.Unchanged = append(diff.Unchanged, newDown)
+		} else {
+			LogIfVerbose("Detected failed checksum on %s\n", path)
+			diff.LocallyModified = append(diff.LocallyModified, change)
+		}
+	}
+
+	for _, path := range oldPaths {
+		if _, ok := newByPath[path]; !ok {
+			LogIfVerbose("Found deleted file %s\n", path)
+			diff.Deleted = append(diff.Deleted, oldByPath[path])
+		}
+	}
+	return diff
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeDisk maps the path of each file on disk to its hash.
+type fakeDisk map[string]string
+
+func (d fakeDisk) Exists(download Download) bool {
+	_, ok := d[filepath.Clean(download.FullPath)]
+	return ok
+}
+
+func (d fakeDisk) Matches(download Download) bool {
+	return d[filepath.Clean(download.FullPath)] == download.Hash
+}
+
+func file(path string, hash string) Download {
+	return Download{Path: filepath.Dir(path), Name: filepath.Base(path), HashType: "sha1", Hash: hash, FullPath: path}
+}
+
+func paths(downloads []Download) []string {
+	ret := []string{}
+	for _, download := range downloads {
+		ret = append(ret, download.FullPath)
+	}
+	return ret
+}
+
+func changePaths(changes []FileChange) []string {
+	ret := []string{}
+	for _, change := range changes {
+		ret = append(ret, change.New.FullPath)
+	}
+	return ret
+}
+
+func TestDiffFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		old    []Download
+		new    []Download
+		disk   fakeDisk
+		verify bool
+
+		added, changed, unchanged, deleted, modified, missing []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "fresh install",
+			new:   []Download{file("mods/b.jar", "2"), file("mods/a.jar", "1")},
+			added: []string{"mods/a.jar", "mods/b.jar"},
+		},
+		{
+			name:      "new files after the last old file",
+			old:       []Download{file("a", "1")},
+			new:       []Download{file("a", "1"), file("b", "2"), file("c", "3")},
+			disk:      fakeDisk{"a": "1"},
+			added:     []string{"b", "c"},
+			unchanged: []string{"a"},
+		},
+		{
+			name:      "deleted files after the last new file",
+			old:       []Download{file("a", "1"), file("b", "2"), file("c", "3")},
+			new:       []Download{file("a", "1")},
+			disk:      fakeDisk{"a": "1", "b": "2", "c": "3"},
+			unchanged: []string{"a"},
+			deleted:   []string{"b", "c"},
+		},
+		{
+			name:    "every new file before the first old file",
+			old:     []Download{file("x", "1")},
+			new:     []Download{file("a", "2"), file("b", "3")},
+			disk:    fakeDisk{"x": "1"},
+			added:   []string{"a", "b"},
+			deleted: []string{"x"},
+		},
+		{
+			name:      "interleaved",
+			old:       []Download{file("e", "5"), file("a", "1"), file("c", "3")},
+			new:       []Download{file("b", "2"), file("c", "33"), file("d", "4"), file("a", "1")},
+			disk:      fakeDisk{"a": "1", "c": "3", "e": "5"},
+			added:     []string{"b", "d"},
+			changed:   []string{"c"},
+			unchanged: []string{"a"},
+			deleted:   []string{"e"},
+		},
+		{
+			name:     "changed file modified locally",
+			old:      []Download{file("config/a.toml", "1"), file("config/b.toml", "2")},
+			new:      []Download{file("config/a.toml", "11"), file("config/b.toml", "22")},
+			disk:     fakeDisk{"config/a.toml": "local", "config/b.toml": "2"},
+			changed:  []string{"config/b.toml"},
+			modified: []string{"config/a.toml"},
+		},
+		{
+			name:      "changed file already updated on disk",
+			old:       []Download{file("a", "1")},
+			new:       []Download{file("a", "2")},
+			disk:      fakeDisk{"a": "2"},
+			unchanged: []string{"a"},
+		},
+		{
+			name:    "missing files",
+			old:     []Download{file("a", "1"), file("b", "2")},
+			new:     []Download{file("a", "1"), file("b", "22")},
+			missing: []string{"a", "b"},
+		},
+		{
+			name:      "unchanged file modified locally is not hashed by default",
+			old:       []Download{file("a", "1")},
+			new:       []Download{file("a", "1")},
+			disk:      fakeDisk{"a": "local"},
+			unchanged: []string{"a"},
+		},
+		{
+			name:      "unchanged file modified locally with verify",
+			old:       []Download{file("a", "1"), file("b", "2")},
+			new:       []Download{file("a", "1"), file("b", "2")},
+			disk:      fakeDisk{"a": "local", "b": "2"},
+			verify:    true,
+			modified:  []string{"a"},
+			unchanged: []string{"b"},
+		},
+		{
+			name:      "paths are compared cleaned",
+			old:       []Download{file("./mods/a.jar", "1")},
+			new:       []Download{file("mods/a.jar", "1")},
+			disk:      fakeDisk{"mods/a.jar": "1"},
+			unchanged: []string{"mods/a.jar"},
+		},
+		{
+			name:    "last of duplicate paths wins",
+			old:     []Download{file("a", "1")},
+			new:     []Download{file("a", "1"), file("a", "2")},
+			disk:    fakeDisk{"a": "1"},
+			changed: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffFiles(tt.old, tt.new, tt.disk, tt.verify)
+			check := func(kind string, got []string, want []string) {
+				if want == nil {
+					want = []string{}
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", kind, got, want)
+				}
+			}
+			check("added", paths(diff.Added), tt.added)
+			check("changed", changePaths(diff.Changed), tt.changed)
+			check("unchanged", paths(diff.Unchanged), tt.unchanged)
+			check("deleted", paths(diff.Deleted), tt.deleted)
+			check("locally modified", changePaths(diff.LocallyModified), tt.modified)
+			check("missing", paths(diff.Missing), tt.missing)
+		})
+	}
+}
+
+func TestDiffFilesKeepsInputOrder(t *testing.T) {
+	oldFiles := []Download{file("b", "1"), file("a", "1")}
+	newFiles := []Download{file("d", "1"), file("c", "1")}
+	DiffFiles(oldFiles, newFiles, fakeDisk{}, false)
+	if oldFiles[0].FullPath != "b" || newFiles[0].FullPath != "d" {
+		t.Errorf("DiffFiles reordered its input: %v %v", paths(oldFiles), paths(newFiles))
+	}
+}
+
+func TestUpdateDiffDownloads(t *testing.T) {
+	diff := UpdateDiff{
+		Added:   []Download{file("added", "1")},
+		Changed: []FileChange{{file("changed", "1"), file("changed", "2")}},
+		LocallyModified: []FileChange{
+			{file("updated", "1"), file("updated", "2")},
+			{file("kept", "1"), file("kept", "1")},
+		},
+		Missing: []Download{file("missing", "1")},
+	}
+
+	if got, want := paths(diff.Downloads(false)), []string{"changed", "missing", "added"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Downloads(false) = %v, want %v", got, want)
+	}
+	if got, want := paths(diff.Downloads(true)), []string{"changed", "updated", "kept", "missing", "added"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Downloads(true) = %v, want %v", got, want)
+	}
+	if got, want := changePaths(diff.ModifiedUpdates()), []string{"updated"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ModifiedUpdates() = %v, want %v", got, want)
+	}
+	if got, want := changePaths(diff.ModifiedUnchanged()), []string{"kept"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ModifiedUnchanged() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// diskFree is the space available to this user on the file system holding
+// path.
+func diskFree(path string) (error, uint64) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return err, 0
+	}
+	return nil, uint64(stat.Bavail) * uint64(stat.Bsize)
+}
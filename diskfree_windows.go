@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskFree is the space available to this user on the volume holding path.
+func diskFree(path string) (error, uint64) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err, 0
+	}
+	var free uint64
+	ret, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if ret == 0 {
+		return err, 0
+	}
+	return nil, free
+}
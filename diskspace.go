@@ -0,0 +1,53 @@
+package main
+
+import "path/filepath"
+
+const (
+	// unknownFileSize is assumed for downloads without a size, such as mod
+	// loader libraries.
+	unknownFileSize = 1 << 20
+	// extractFactor is how much bigger an archive gets once extracted, on top
+	// of the archive itself.
+	extractFactor = 3
+	// spaceMargin is the share of space kept spare on top of the estimate.
+	spaceMargin = 10
+)
+
+// RequiredSpace estimates the bytes needed for downloads, including the
+// extracted contents of the archives in extracted.
+func RequiredSpace(downloads []Download, extracted []Download) int64 {
+	var total int64
+	for _, down := range downloads {
+		if down.Size > 0 {
+			total += down.Size
+		} else {
+			total += unknownFileSize
+		}
+	}
+	for _, down := range extracted {
+		size := down.Size
+		if size <= 0 {
+			size = unknownFileSize
+		}
+		total += size * extractFactor
+	}
+	return total
+}
+
+// CheckDiskSpace makes sure the file system holding installPath has room for
+// required bytes, asking whether to carry on if it doesn't. Nothing is
+// checked if the free space can't be found.
+func CheckDiskSpace(installPath string, required int64) {
+	err, free := diskFree(filepath.Clean(installPath))
+	if err != nil {
+		LogIfVerbose("Unable to check free space on %s: %v\n", installPath, err)
+		return
+	}
+	LogIfVerbose("Need about %s, %s free on %s\n", formatSize(required), formatSize(int64(free)), installPath)
+	if uint64(required+required/spaceMargin) <= free {
+		return
+	}
+	if !QuestionYN(false, "This needs about %s of disk space but only %s is free on %s. Do you wish to continue anyway?", formatSize(required), formatSize(int64(free)), installPath) {
+		fatalf("Not enough disk space")
+	}
+}
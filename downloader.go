@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/cavaliergopher/grab/v3"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// partSuffix marks files that are still being downloaded. They are only
+// renamed into place once complete and verified.
+const partSuffix = ".part"
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+	// retryMaxAfter caps how long a Retry-After header can make us wait.
+	retryMaxAfter = 2 * time.Minute
+)
+
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// Transfer is one file of a batch, across every attempt at fetching it.
+type Transfer struct {
+	Download Download
+	Filename string
+
+	mu       sync.Mutex
+	resp     *grab.Response
+	attempts int
+	err      error
+	done     bool
+	cached   bool
+	url      url.URL
+}
+
+// Response is the grab response of the current, or last, attempt.
+func (t *Transfer) Response() *grab.Response {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.resp
+}
+
+func (t *Transfer) Attempts() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.attempts
+}
+
+// Err is the error of the last attempt, once the transfer is complete.
+func (t *Transfer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// IsComplete is true once the file was fetched or retries were given up on.
+func (t *Transfer) IsComplete() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.done
+}
+
+// Cached is true if the file was taken from the download cache.
+func (t *Transfer) Cached() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cached
+}
+
+// URL is where the current, or last, attempt downloads from.
+func (t *Transfer) URL() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.url.String()
+}
+
+// newRequest fetches download from u into the part file for filename. A part
+// file left by an earlier run is resumed if the server supports ranges, but
+// only when the download has a checksum to catch a stale or corrupt part.
+func newRequest(filename string, download Download, u url.URL) (*grab.Request, error) {
+	req, err := grab.NewRequest(filename+partSuffix, u.String())
+	if err != nil {
+		return nil, err
+	}
+	req.NoResume = true
+	// grab checks this against the Content-Length before downloading.
+	req.Size = download.Size
+	if limiter != nil {
+		req.RateLimiter = limiter
+		req.BufferSize = limiter.bufferSize()
+	}
+	// TODO, Download should have a function to get the 'validation properties'
+	//  this could unify some hash handling.
+	if len(download.HashType) != 0 && len(download.Hash) != 0 {
+		req.NoResume = false
+		byteHex, _ := hex.DecodeString(download.Hash)
+		hashType := crypto.SHA1 // Ideally i want null default.
+		switch download.HashType {
+		case "sha1":
+			hashType = crypto.SHA1
+		case "sha256":
+			hashType = crypto.SHA256
+		}
+		req.SetChecksum(hashType.New(), byteHex, true)
+	}
+	return req, nil
+}
+
+// fetch makes one attempt at downloading the file from u, holding one of the
+// batch's worker slots and a slot for the host while it runs. The transfer is
+// sent on started when its first attempt begins.
+func (t *Transfer) fetch(ctx context.Context, client *grab.Client, u url.URL, workers chan struct{}, started chan<- *Transfer) (*grab.Response, error) {
+	req, err := newRequest(t.Filename, t.Download, u)
+	if err != nil {
+		// Still started, so the failure is reported with the batch.
+		t.begin(u, started)
+		return nil, err
+	}
+	releaseHost := acquireHost(u.Host)
+	defer releaseHost()
+	workers <- struct{}{}
+	defer func() { <-workers }()
+
+	t.begin(u, started)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resp := client.Do(req.WithContext(ctx))
+	t.mu.Lock()
+	t.resp = resp
+	t.mu.Unlock()
+	<-resp.Done
+
+	err = resp.Err()
+	if err == nil && t.Download.Size > 0 && resp.BytesComplete() != t.Download.Size {
+		err = fmt.Errorf("%w: expected %d bytes but got %d", grab.ErrBadLength, t.Download.Size, resp.BytesComplete())
+	}
+	if err == nil {
+		size := resp.BytesComplete()
+		if resp.DidResume {
+			// Too little of it was downloaded now to tell the speed.
+			size = 0
+		}
+		mirrorSucceeded(u.Host, size, resp.Duration())
+		if err = os.Rename(resp.Filename, t.Filename); err == nil {
+			toCache(t.Download, t.Filename)
+		}
+		return resp, err
+	}
+	if errors.Is(err, grab.ErrBadLength) || (ctx.Err() != nil && req.NoResume) {
+		// The part file doesn't match the remote file, or can't be resumed
+		// after being cancelled; start over.
+		os.Remove(resp.Filename)
+	}
+	if failover(err) {
+		mirrorFailed(u.Host)
+	}
+	return resp, err
+}
+
+// begin counts an attempt at u, sending the transfer on started if it is the
+// first.
+func (t *Transfer) begin(u url.URL, started chan<- *Transfer) {
+	t.mu.Lock()
+	t.url = u
+	t.attempts++
+	first := t.attempts == 1
+	t.mu.Unlock()
+	if first {
+		started <- t
+	}
+}
+
+// run fetches the file, trying each candidate URL in turn, and retries the
+// whole list for failures that may go away on their own.
+func (t *Transfer) run(ctx context.Context, client *grab.Client, workers chan struct{}, started chan<- *Transfer) {
+	if fromCache(t.Download, t.Filename) {
+		t.mu.Lock()
+		t.cached = true
+		t.done = true
+		t.mu.Unlock()
+		started <- t
+		return
+	}
+
+	for round := 1; ; round++ {
+		var resp *grab.Response
+		var err error
+		candidates := t.Download.Candidates()
+		for i, candidate := range candidates {
+			resp, err = t.fetch(ctx, client, candidate, workers, started)
+			if err == nil || !failover(err) {
+				break
+			}
+			if i < len(candidates)-1 {
+				LogIfVerbose("Download from %s failed: %v. Trying the next mirror\n", candidate.String(), err)
+			}
+		}
+		if err == nil || round > Options.Retries || !retryable(err) {
+			t.mu.Lock()
+			t.err = err
+			t.done = true
+			t.mu.Unlock()
+			return
+		}
+
+		delay := retryDelay(round, resp)
+		LogIfVerbose("Attempt %d at %s failed: %v. Retrying in %v\n", t.Attempts(), t.URL(), err, delay.Round(time.Millisecond))
+		emit(Event{Event: "retry", File: t.Filename, URL: t.URL(), Attempt: t.Attempts(), Error: err.Error()})
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// failover reports whether err is worth trying another mirror for.
+func failover(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pathErr *os.PathError
+	return !errors.As(err, &pathErr)
+}
+
+// retryable reports whether err may be gone on the next attempt.
+func retryable(err error) bool {
+	var status grab.StatusCodeError
+	if errors.As(err, &status) {
+		switch int(status) {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// Connection errors, checksum and length mismatches. Local file system
+	// errors won't fix themselves.
+	return failover(err)
+}
+
+// retryDelay is how long to wait before the next attempt: the server's
+// Retry-After on 429 and 503, otherwise exponential backoff with jitter.
+func retryDelay(attempt int, resp *grab.Response) time.Duration {
+	if resp != nil && resp.HTTPResponse != nil {
+		code := resp.HTTPResponse.StatusCode
+		if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+			if delay, ok := parseRetryAfter(resp.HTTPResponse.Header.Get("Retry-After")); ok {
+				if delay > retryMaxAfter {
+					delay = retryMaxAfter
+				}
+				return delay
+			}
+		}
+	}
+
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = retryBaseDelay << (attempt - 1)
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+	// Wait somewhere between half and all of the delay, so that workers
+	// that failed together don't all retry together.
+	jitter.Lock()
+	defer jitter.Unlock()
+	return delay/2 + time.Duration(jitter.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter reads a Retry-After value in seconds or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// Filename is where the download is saved when installing into installPath.
+func (d Download) Filename(installPath string) string {
+	dir := d.Path
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(installPath, dir)
+	}
+	return filepath.Join(dir, d.Name)
+}
+
+// GetBatch starts fetching downloads into dst, workers at a time. Each
+// Transfer is sent on the channel as its first attempt starts, and the channel
+// is closed once they are all complete.
+func GetBatch(ctx context.Context, workers int, dst string, downloads ...Download) (<-chan *Transfer, error) {
+	fi, err := os.Stat(dst)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("destination is not a directory")
+	}
+
+	transfers := make([]*Transfer, len(downloads))
+	for i := 0; i < len(downloads); i++ {
+		download := downloads[i]
+		filename := download.Filename(dst)
+		if _, err := newRequest(filename, download, download.URL); err != nil {
+			return nil, err
+		}
+		transfers[i] = &Transfer{Download: download, Filename: filename, url: download.URL}
+	}
+
+	if workers < 1 {
+		workers = len(downloads)
+	}
+	slots := make(chan struct{}, workers)
+	ch := make(chan *Transfer, len(downloads))
+	wg := sync.WaitGroup{}
+	for i := range transfers {
+		wg.Add(1)
+		go func(t *Transfer) {
+			defer wg.Done()
+			t.run(ctx, downloadClient, slots, ch)
+		}(transfers[i])
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is one line of the stream written to stdout with --output json while
+// installing, updating or repairing.
+type Event struct {
+	Time     string   `json:"time"`
+	Event    string   `json:"event"`
+	Phase    string   `json:"phase,omitempty"`
+	File     string   `json:"file,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Attempt  int      `json:"attempt,omitempty"`
+	Cached   bool     `json:"cached,omitempty"`
+	Bytes    int64    `json:"bytes,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Question string   `json:"question,omitempty"`
+	Answer   string   `json:"answer,omitempty"`
+	Summary  *Summary `json:"summary,omitempty"`
+}
+
+type Summary struct {
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exitCode"`
+	Message    string `json:"message,omitempty"`
+	Succeeded  int    `json:"succeeded"`
+	Failed     int    `json:"failed"`
+	Incomplete int    `json:"incomplete"`
+	Changed    int    `json:"changed"`
+	New        int    `json:"new"`
+	Missing    int    `json:"missing"`
+	Deleted    int    `json:"deleted"`
+	Retried    int    `json:"retried"`
+
+	Failures []FailedDownload `json:"failures,omitempty"`
+	// Merged are configs the update merged with local changes, and
+	// Conflicts those left with conflicts to resolve.
+	Merged    []string `json:"merged,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	// Protected are files left alone because of the protectFile.
+	Protected []string `json:"protected,omitempty"`
+}
+
+type FailedDownload struct {
+	File     string `json:"file"`
+	URL      string `json:"url"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+var (
+	eventsMu sync.Mutex
+	// runSummary collects the counts reported in the final summary event.
+	runSummary Summary
+)
+
+func eventsEnabled() bool {
+	return Options.Output == "json" && !Options.Dryrun
+}
+
+func emit(event Event) {
+	if !eventsEnabled() {
+		return
+	}
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	json.NewEncoder(os.Stdout).Encode(event)
+}
+
+func emitPhase(phase string) {
+	emit(Event{Event: "phase", Phase: phase})
+}
+
+// exitHooks clean up temporary files. They run in reverse order on exit.
+var exitHooks []func()
+var exitHooksMu sync.Mutex
+
+func atExit(hook func()) {
+	exitHooksMu.Lock()
+	defer exitHooksMu.Unlock()
+	exitHooks = append(exitHooks, hook)
+}
+
+// exitMu is never unlocked, so only the first call to exit does anything.
+var exitMu sync.Mutex
+
+// exit writes the summary event, if enabled, runs the exit hooks and exits
+// with code, or with exitInterrupted after a signal.
+func exit(code int, message string) {
+	exitMu.Lock()
+	if isInterrupted() {
+		code = exitInterrupted
+		message = "interrupted"
+	}
+	runSummary.ExitCode = code
+	runSummary.Message = message
+	runSummary.Succeeded = succeeded
+	runSummary.Failed = failed
+	runSummary.Incomplete = inProgress
+	runSummary.Retried = retried
+	if code == 0 {
+		runSummary.Status = "success"
+	} else if code == exitInterrupted {
+		runSummary.Status = "interrupted"
+	} else {
+		runSummary.Status = "failed"
+	}
+	summary := runSummary
+	emit(Event{Event: "summary", Summary: &summary})
+	exitHooksMu.Lock()
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(code)
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -34,10 +34,18 @@ type FabricMetaInstaller []struct {
 
 type Fabric struct {
 	FabricVersion
-	metaCache      FabricMeta
+	cache          *fabricCache
 	InstallerCache FabricMetaInstaller
 }
 
+// fabricCache is shared by the copies of a Fabric, so installing it doesn't
+// look up again what planning the install already did.
+type fabricCache struct {
+	meta          FabricMeta
+	downloadsPath string
+	downloads     []Download
+}
+
 type FabricMeta struct {
 	Libraries []struct {
 		Name string `json:"name"`
@@ -46,11 +54,14 @@ type FabricMeta struct {
 	MainClass string `json:"mainClass"`
 }
 
-func (f *Fabric) getMeta() FabricMeta {
-	if len(f.metaCache.Libraries) == 0 {
+func (f *Fabric) getMeta(ctx context.Context) FabricMeta {
+	if f.cache == nil {
+		f.cache = &fabricCache{}
+	}
+	if len(f.cache.meta.Libraries) == 0 {
 		var meta = FabricMeta{}
 		var url = fmt.Sprintf(FABRIC_META+FABRIC_SERVER_JSON, f.Minecraft.RawVersion, f.RawVersion)
-		resp, err := http.Get(url)
+		resp, err := httpGet(ctx, url)
 		if err != nil {
 			fatalf("Error getting fabric meta for Minecraft %s Fabric %s: %v", f.Minecraft.RawVersion, f.RawVersion, err)
 		}
@@ -66,20 +77,23 @@ func (f *Fabric) getMeta() FabricMeta {
 			fatalf("Error parsing fabric meta for Minecraft %s Fabric %s: %v", f.Minecraft.RawVersion, f.RawVersion, err)
 		}
 
-		f.metaCache = meta
+		f.cache.meta = meta
 	}
 
-	return f.metaCache
+	return f.cache.meta
 }
 
-func (f Fabric) GetDownloads(installPath string) []Download {
+func (f Fabric) GetDownloads(ctx context.Context, installPath string) []Download {
+	if f.cache != nil && f.cache.downloads != nil && f.cache.downloadsPath == installPath {
+		return f.cache.downloads
+	}
 	printfln("Getting downloads for Fabric")
-	vanillaVer, err := f.FabricVersion.Minecraft.GetVanillaVersion()
+	vanillaVer, err := f.FabricVersion.Minecraft.GetVanillaVersion(ctx)
 	if err != nil {
 		// handleerr
 	}
 
-	serverDownload, err := vanillaVer.GetServerDownload()
+	serverDownload, err := vanillaVer.GetServerDownload(ctx)
 	if err != nil {
 		// handleerr
 	}
@@ -103,33 +117,37 @@ func (f Fabric) GetDownloads(installPath string) []Download {
 			FullPath: filepath.Join(installPath, iFileName),
 		})
 	} else {
-		meta := f.getMeta()
+		meta := f.getMeta(ctx)
 		homeDir := getFabricHomeDir()
 		for _, library := range meta.Libraries {
 			mavenURL, filename := getMavenUrl(library.Name)
-			mavenURL = GetMirrorFor(mavenURL, library.URL)
-			sha1URL := mavenURL + ".sha1"
-			sha1 := getOrBlank(sha1URL)
-
-			parse, err := url.Parse(mavenURL)
-			if err != nil {
+			mirrors := MirrorsFor(strings.TrimSuffix(library.URL, "/")+"/"+mavenURL, "", "")
+			if len(mirrors) == 0 {
 				//shrug
 				continue
 			}
+			sha1 := ""
+			for _, mirror := range mirrors {
+				if sha1 = getOrBlank(ctx, mirror.String()+".sha1"); len(sha1) > 0 {
+					break
+				}
+			}
 
-			downloads = append(downloads, Download{filepath.Join(homeDir, ".cache"), *parse, filename, "sha1", sha1, filepath.Join(homeDir, ".cache", filename)})
+			downloads = append(downloads, Download{filepath.Join(homeDir, ".cache"), mirrors[0], filename, "sha1", sha1, filepath.Join(homeDir, ".cache", filename), mirrors[1:], 0})
 		}
 	}
 
+	if f.cache != nil {
+		f.cache.downloadsPath = installPath
+		f.cache.downloads = downloads
+	}
 	return downloads
 }
 
-func (f Fabric) Install(installPath string, java JavaProvider) bool {
+func (f Fabric) Install(ctx context.Context, installPath string, java JavaProvider) bool {
 	printfln("Installing Fabric")
 	serverName := fmt.Sprintf("fabric-%s-%s-server-launch.jar", f.Minecraft.RawVersion, f.FabricVersion.RawVersion)
-	meta := f.getMeta()
-
-	downloads := f.GetDownloads(installPath)
+	downloads := f.GetDownloads(ctx, installPath)
 
 	var jars []string
 
@@ -139,21 +157,14 @@ func (f Fabric) Install(installPath string, java JavaProvider) bool {
 		}
 	}
 
-	vanillaVer, err := f.FabricVersion.Minecraft.GetVanillaVersion()
-	if err != nil {
-		// handleerr
-	}
-
-	serverDownload, err := vanillaVer.GetServerDownload()
-	if err != nil {
-		// handleerr
-	}
+	// GetDownloads lists the vanilla server first.
+	serverDownload := downloads[0]
 	fVersion, _ := version.NewVersion(f.RawVersion)
 	autoVersion, _ := version.NewVersion("0.12.0")
 
 	if !fVersion.GreaterThanOrEqual(autoVersion) {
 		os.WriteFile(filepath.Join(installPath, "fabric-server-launcher.properties"), []byte("serverJar="+serverDownload.Name+"\n"), 0644)
-		mergeZips(jars, filepath.Join(installPath, serverName), false, meta.MainClass)
+		mergeZips(jars, filepath.Join(installPath, serverName), false, f.getMeta(ctx).MainClass)
 	}
 
 	return true
@@ -163,17 +174,17 @@ func (f Fabric) GetLaunchJar(installPath string) (string, []string) {
 	return fmt.Sprintf("fabric-%s-%s-server-launch.jar", f.Minecraft.RawVersion, f.RawVersion), nil
 }
 
-func GetFabric(modloader Target, mc Minecraft) (error, ModLoader) {
-	fab := Fabric{}
+func GetFabric(ctx context.Context, modloader Target, mc Minecraft) (error, ModLoader) {
+	fab := Fabric{cache: &fabricCache{}}
 	fab.FabricVersion.RawVersion = modloader.Version
 	fab.FabricVersion.Minecraft = mc
-	fab.InstallerCache = getInstaller()
+	fab.InstallerCache = getInstaller(ctx)
 	return nil, fab
 }
 
-func getInstaller() FabricMetaInstaller {
+func getInstaller(ctx context.Context) FabricMetaInstaller {
 	var url = FABRIC_META + "/v2/versions/installer"
-	resp, err := http.Get(url)
+	resp, err := httpGet(ctx, url)
 	if err != nil {
 		fatalf("error getting fabric meta for Minecraft %s Fabric", err)
 	}
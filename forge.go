@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
@@ -14,11 +14,9 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-
-	"github.com/cavaliergopher/grab/v3"
 )
 
-func GetForge(modloader Target, mc Minecraft) (error, ModLoader) {
+func GetForge(ctx context.Context, modloader Target, mc Minecraft) (error, ModLoader) {
 	version := ForgeVersion{}
 	version.RawVersion = modloader.Version
 	version.Minecraft = mc
@@ -90,39 +88,41 @@ type ForgeUniversal struct {
 	Version ForgeVersion
 }
 
-func (f ForgeUniversal) GetDownloads(installPath string) []Download {
+func (f ForgeUniversal) GetDownloads(ctx context.Context, installPath string) []Download {
 	printfln("Getting downloads for Forge Universal")
 	versionStr := fmt.Sprintf(versionFmt, f.Version.Minecraft.RawVersion, f.Version.RawVersion)
 	versionStrOther := fmt.Sprintf(versionFmtOther, f.Version.Minecraft.RawVersion, f.Version.RawVersion, f.Version.Minecraft.RawVersion)
 	universalName := fmt.Sprintf("forge-%s-universal.jar", versionStr)
 	universalNameOther := fmt.Sprintf("forge-%s-universal.jar", versionStrOther)
-	forgeUrl := fmt.Sprintf(forgeUrlUniversalJar, versionStr, universalName)
-	forgeUrl = GetMirrorFor(forgeUrl, "https://apps.modpacks.ch/versions/")
-	forgeUrlOther := fmt.Sprintf(forgeUrlUniversalJar, versionStrOther, universalNameOther)
-	forgeUrlJSON := fmt.Sprintf(forgeUrlInstallJSON, versionStr, versionStr)
-	forgeUrlJSONOther := fmt.Sprintf(forgeUrlInstallJSON, versionStrOther, versionStrOther)
+	forgeUrl := mavenURL("forge", fmt.Sprintf(forgeUrlUniversalJar, versionStr, universalName))
+	forgeUrlOther := mavenURL("forge", fmt.Sprintf(forgeUrlUniversalJar, versionStrOther, universalNameOther))
+	forgeUrlJSON := mavenURL("forge", fmt.Sprintf(forgeUrlInstallJSON, versionStr, versionStr))
+	forgeUrlJSONOther := mavenURL("forge", fmt.Sprintf(forgeUrlInstallJSON, versionStrOther, versionStrOther))
 	var rawForgeJSON []byte
-	if !FileOnServer(forgeUrlJSON) {
+	if !FileOnServer(ctx, forgeUrlJSON) {
 		forgeUrlJSON = forgeUrlJSONOther
 	}
-	if !FileOnServer(forgeUrl) {
+	if !FileOnServer(ctx, forgeUrl) {
 		forgeUrl = forgeUrlOther
 		universalName = universalNameOther
 	}
-	if !FileOnServer(forgeUrlJSON) {
-		resp, err := grab.Get(installPath, forgeUrl)
+	if !FileOnServer(ctx, forgeUrlJSON) {
+		// Only needed to read the json from, so keep it out of the install until
+		// the real download.
+		resp, err := grabGet(ctx, os.TempDir(), forgeUrl)
 		if err != nil {
 			fatalf("JSON not on server and unable to get forge jar: %v", err)
 		}
 		if resp.IsComplete() {
 			resp.Wait()
 		}
-		bytes, err := UnzipFileToMemory(filepath.Join(installPath, universalName), "version.json")
+		defer os.Remove(resp.Filename)
+		bytes, err := UnzipFileToMemory(resp.Filename, "version.json")
 		if err == nil {
 			rawForgeJSON = bytes
 		}
 	} else {
-		resp, err := http.Get(forgeUrlJSON)
+		resp, err := httpGet(ctx, forgeUrlJSON)
 		if err == nil {
 			defer resp.Body.Close()
 			bytes, err := io.ReadAll(resp.Body)
@@ -136,7 +136,7 @@ func (f ForgeUniversal) GetDownloads(installPath string) []Download {
 	if err != nil {
 		fatalf("Unable to get forge jar as error parsing URL somehow: URL: %s, Error: %v", forgeUrl, err)
 	}
-	downloads := []Download{{"", *URL, universalName, "", "", filepath.Join("", universalName)}}
+	downloads := []Download{Download{"", *URL, universalName, "", "", filepath.Join("", universalName), nil, 0}.WithMirrors("https://apps.modpacks.ch/versions/", "forge")}
 
 	if len(rawForgeJSON) > 0 {
 		versionForge := VersionJson{}
@@ -149,9 +149,9 @@ func (f ForgeUniversal) GetDownloads(installPath string) []Download {
 	} else {
 		fatalf("Cannot get a json to download the libraries which is required.")
 	}
-	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion()
+	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion(ctx)
 	if err == nil {
-		serverDownload, err := vanillaVer.GetServerDownload()
+		serverDownload, err := vanillaVer.GetServerDownload(ctx)
 		if err == nil {
 			downloads = append(downloads, serverDownload)
 		}
@@ -162,7 +162,7 @@ func (f ForgeUniversal) GetDownloads(installPath string) []Download {
 	return downloads
 }
 
-func (f ForgeUniversal) Install(installPath string, java JavaProvider) bool {
+func (f ForgeUniversal) Install(ctx context.Context, installPath string, java JavaProvider) bool {
 	return true
 }
 
@@ -185,59 +185,78 @@ func (f ForgeUniversal) GetLaunchJar(installPath string) (string, []string) {
 const versionFmt = "%s-%s"
 const versionFmtOther = "%s-%s-%s"
 
-const forgeUrlUniversalJar = "https://maven.creeperhost.net/net/minecraftforge/forge/%s/%s"
-const forgeUrlInstallJar = "https://maven.creeperhost.net/net/minecraftforge/forge/%s/%s"
-const forgeUrlInstallJSON = "https://maven.creeperhost.net/net/minecraftforge/forge/%s/forge-%s.json"
+// Paths of Forge artifacts in a Maven repository.
+const forgeUrlUniversalJar = "net/minecraftforge/forge/%s/%s"
+const forgeUrlInstallJar = "net/minecraftforge/forge/%s/%s"
+const forgeUrlInstallJSON = "net/minecraftforge/forge/%s/forge-%s.json"
+
+// readInstallerJsons reads the version.json, from versionUrl if it is there,
+// and the install_profile.json of the installer at installerUrl. The installer
+// is fetched to a temporary folder to read them from, as it isn't in the
+// install until the real download. The profile lists the libraries the
+// installer's processors need, so without it they aren't downloaded or
+// bundled.
+func readInstallerJsons(ctx context.Context, installerUrl string, versionUrl string) (error, []byte, []byte) {
+	var rawVersion []byte
+	var rawProfile []byte
+	jsonOnServer := FileOnServer(ctx, versionUrl)
+	if jsonOnServer {
+		resp, err := httpGet(ctx, versionUrl)
+		if err == nil {
+			defer resp.Body.Close()
+			bytes, err := io.ReadAll(resp.Body)
+			if err == nil {
+				rawVersion = bytes
+			}
+		}
+	}
 
-func GetMirrors() []string {
-	return []string{"https://maven.creeperhost.net/", "https://libraries.minecraft.net/"}
+	dir, err := os.MkdirTemp("", "modpacksch-installer-")
+	if err != nil {
+		return err, nil, nil
+	}
+	defer os.RemoveAll(dir)
+	resp, err := grabGet(ctx, dir, installerUrl)
+	if err != nil {
+		if !jsonOnServer {
+			return err, nil, nil
+		}
+		printfln("Unable to read the installer's libraries, it will download them itself: %v", err)
+		return nil, rawVersion, nil
+	}
+	if !jsonOnServer {
+		bytes, err := UnzipFileToMemory(resp.Filename, "version.json")
+		if err == nil {
+			rawVersion = bytes
+		}
+	}
+	bytes, err := UnzipFileToMemory(resp.Filename, "install_profile.json")
+	if err == nil {
+		rawProfile = bytes
+	}
+	return nil, rawVersion, rawProfile
 }
 
 type ForgeInstall struct {
 	Version ForgeVersion
 }
 
-func (f ForgeInstall) GetDownloads(installPath string) []Download {
+func (f ForgeInstall) GetDownloads(ctx context.Context, installPath string) []Download {
 	printfln("Getting downloads for Forge Install")
 	versionStr := fmt.Sprintf(versionFmt, f.Version.Minecraft.RawVersion, f.Version.RawVersion)
 	installerName := fmt.Sprintf("forge-%s-installer.jar", versionStr)
-	forgeUrl := fmt.Sprintf(forgeUrlInstallJar, versionStr, installerName)
-	forgeUrlJSON := fmt.Sprintf(forgeUrlInstallJSON, versionStr, versionStr)
-	var rawForgeJSON []byte
-	var rawForgeInstallJSON []byte
-	if !FileOnServer(forgeUrlJSON) {
-		resp, err := grab.Get(installPath, forgeUrl)
-		if err != nil {
-			fatalf("JSON not on server and unable to get forge jar: %v", err)
-		}
-		if resp.IsComplete() {
-			resp.Wait()
-		}
-		bytes, err := UnzipFileToMemory(filepath.Join(installPath, installerName), "version.json")
-		if err == nil {
-			rawForgeJSON = bytes
-		}
-	} else {
-		resp, err := http.Get(forgeUrlJSON)
-		if err == nil {
-			defer resp.Body.Close()
-			bytes, err := io.ReadAll(resp.Body)
-			if err == nil {
-				rawForgeJSON = bytes
-			}
-		}
-	}
-
-	bytes, err := UnzipFileToMemory(filepath.Join(installPath, installerName), "install_profile.json")
-	if err == nil {
-		rawForgeInstallJSON = bytes
+	forgeUrl := mavenURL("forge", fmt.Sprintf(forgeUrlInstallJar, versionStr, installerName))
+	forgeUrlJSON := mavenURL("forge", fmt.Sprintf(forgeUrlInstallJSON, versionStr, versionStr))
+	err, rawForgeJSON, rawForgeInstallJSON := readInstallerJsons(ctx, forgeUrl, forgeUrlJSON)
+	if err != nil {
+		fatalf("JSON not on server and unable to get forge jar: %v", err)
 	}
 
 	URL, err := url.Parse(forgeUrl)
 	if err != nil {
 		fatalf("Unable to get forge jar as error parsing URL somehow: URL: %s, Error: %v", forgeUrl, err)
 	}
-	downloads := []Download{{"", *URL, installerName, "", "", filepath.Join("", installerName+".jar")}}
+	downloads := []Download{Download{"", *URL, installerName, "", "", filepath.Join("", installerName+".jar"), nil, 0}.WithMirrors("", "forge")}
 
 	if len(rawForgeJSON) > 0 {
 		versionForge := VersionJsonFG3{}
@@ -253,9 +272,9 @@ func (f ForgeInstall) GetDownloads(installPath string) []Download {
 			downloads = append(downloads, versionForge.GetDownloads()...)
 		}
 	}
-	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion()
+	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion(ctx)
 	if err == nil {
-		serverDownload, err := vanillaVer.GetServerDownload()
+		serverDownload, err := vanillaVer.GetServerDownload(ctx)
 		if err == nil {
 			downloads = append(downloads, serverDownload)
 		}
@@ -266,7 +285,7 @@ func (f ForgeInstall) GetDownloads(installPath string) []Download {
 	return downloads
 }
 
-func (f ForgeInstall) Install(installPath string, java JavaProvider) bool {
+func (f ForgeInstall) Install(ctx context.Context, installPath string, java JavaProvider) bool {
 	printfln("Running Forge installer")
 	retryCount := 0
 Forge:
@@ -281,17 +300,18 @@ Forge:
 	} else {
 		javaPath = java.GetJavaPath("")
 	}
-	fmt.Println("Java Path has been set to:", javaPath)
+	fmt.Fprintln(loggerOut, "Java Path has been set to:", javaPath)
 	LogIfVerbose("Running %s -Xmx%s -jar %s --installServer", javaPath, xmx, installerName)
-	cmd := exec.Command(javaPath, "-Xmx"+xmx, "-jar", installerName, "--installServer")
+	cmd := exec.CommandContext(ctx, javaPath, "-Xmx"+xmx, "-jar", installerName, "--installServer")
 	cmd.Dir = installPath
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = loggerOut
 	cmd.Stderr = os.Stderr
 	if err := cmd.Start(); err != nil {
 		fatalf(fmt.Sprintf("Running forge installer failed with %s. You may wish to install forge %s for Minecraft %s manually", err, f.Version.RawVersion, f.Version.Minecraft.RawVersion))
 		return false
 	}
 	if err := cmd.Wait(); err != nil {
+		stopIfInterrupted(ctx)
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			if exitErr.ExitCode() != 0 {
 				printfln(fmt.Sprintf("Forge installer failed with exit code %d, retrying...", exitErr.ExitCode()))
@@ -361,27 +381,26 @@ type hashName struct {
 	hash string
 }
 
-func (f ForgeInJar) GetDownloads(installPath string) []Download {
+func (f ForgeInJar) GetDownloads(ctx context.Context, installPath string) []Download {
 	printfln("Getting downloads for Forge In Jar")
 	versionStr := fmt.Sprintf(versionFmt, f.Version.Minecraft.RawVersion, f.Version.RawVersion)
 	serverName := fmt.Sprintf("forge-%s-universal.zip", versionStr)
 	if f.Version.Minecraft.RawVersion == "1.2.5" {
 		serverName = fmt.Sprintf("forge-%s-server.zip", versionStr)
 	}
-	forgeUrl := fmt.Sprintf(forgeUrlUniversalJar, versionStr, serverName)
-	forgeUrl = GetMirrorFor(forgeUrl, "https://maven.minecraftforge.net/")
+	forgeUrl := mavenURL("forge", fmt.Sprintf(forgeUrlUniversalJar, versionStr, serverName))
 
 	URL, err := url.Parse(forgeUrl)
 	if err != nil {
 		fatalf("Unable to get forge jar as error parsing URL somehow: URL: %s, Error: %v", forgeUrl, err)
 	}
 
-	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion()
+	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion(ctx)
 	if err != nil {
 		// handleerr
 	}
 
-	serverDownload, err := vanillaVer.GetServerDownload()
+	serverDownload, err := vanillaVer.GetServerDownload(ctx)
 	if err != nil {
 		// handleerr
 	}
@@ -404,7 +423,7 @@ func (f ForgeInJar) GetDownloads(installPath string) []Download {
 		libs["https://maven.creeperhost.net/org/scala-lang/scala-library/2.10.0/scala-library-2.10.0.jar"] = hashName{"scala-library.jar", "458d046151ad179c85429ed7420ffb1eaf6ddf85"}
 	}
 
-	downloads := []Download{serverDownload, {"", *URL, serverName, "", "", filepath.Join("", serverName)}}
+	downloads := []Download{serverDownload, Download{"", *URL, serverName, "", "", filepath.Join("", serverName), nil, 0}.WithMirrors(forgeMaven, "forge")}
 
 	for libUrl, lib := range libs {
 		URL, err := url.Parse(libUrl)
@@ -414,13 +433,13 @@ func (f ForgeInJar) GetDownloads(installPath string) []Download {
 			}
 		}
 		baseName := lib.name
-		downloads = append(downloads, Download{"lib/", *URL, baseName, "sha1", lib.hash, filepath.Join("lib/", baseName)})
+		downloads = append(downloads, Download{"lib/", *URL, baseName, "sha1", lib.hash, filepath.Join("lib/", baseName), nil, 0}.WithMirrors(forgeMaven, "forge"))
 	}
 
 	return downloads
 }
 
-func (f ForgeInJar) Install(installPath string, java JavaProvider) bool {
+func (f ForgeInJar) Install(ctx context.Context, installPath string, java JavaProvider) bool {
 	versionStr := fmt.Sprintf(versionFmt, f.Version.Minecraft.RawVersion, f.Version.RawVersion)
 	serverNameDownloaded := fmt.Sprintf("forge-%s-universal.zip", versionStr)
 	if f.Version.Minecraft.RawVersion == "1.2.5" {
@@ -436,12 +455,12 @@ func (f ForgeInJar) Install(installPath string, java JavaProvider) bool {
 
 	jarMods := listDirectories(directories)
 
-	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion()
+	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion(ctx)
 	if err != nil {
 		// handleerr
 	}
 
-	serverDownload, err := vanillaVer.GetServerDownload()
+	serverDownload, err := vanillaVer.GetServerDownload(ctx)
 	if err != nil {
 		// handleerr
 	}
@@ -490,7 +509,7 @@ func (v VersionJson) GetLibraryDownloads() []Download {
 		if len(artichoke.Hashes) > 0 {
 			hash = artichoke.Hashes[0]
 		}
-		downloads = append(downloads, Download{filepath.Join("libraries", dir), *actualUrl, file, "sha1", hash, filepath.Join("libraries", dir, file)})
+		downloads = append(downloads, Download{filepath.Join("libraries", dir), *actualUrl, file, "sha1", hash, filepath.Join("libraries", dir, file), nil, 0}.WithMirrors(forgeMaven, ""))
 	}
 	return downloads
 }
@@ -518,7 +537,7 @@ func (v *VersionLibrary) UnmarshalJSON(data []byte) error {
 	filename := split[1] + "-" + split[2] + ".jar"
 	pathTemp := strings.Replace(split[0], ".", "/", -1) + "/" + split[1] + "/" + split[2]
 
-	v.Url = GetMirrorFor(pathTemp+"/"+filename, "https://maven.minecraftforge.net/")
+	v.Url = forgeMaven + pathTemp + "/" + filename
 	v.Filename = filename
 	v.Path = pathTemp
 	return nil
@@ -529,27 +548,16 @@ type VersionJsonFG3 struct {
 }
 
 func (v VersionJsonFG3) GetDownloads() []Download {
-	mirrors := GetMirrors()
 	var downloads []Download
 	for _, library := range v.Libraries {
 		artichoke := library.Download.Artifact
 		if len(artichoke.Url) > 0 {
 			dir, file := path.Split(artichoke.Path)
-			actualUrlStr := ""
-		Out:
-			for _, mirror := range mirrors {
-				//actualUrlStr = strings.Replace(artichoke.Url, "https://files.minecraftforge.net/maven/", mirror, 1)
-				//actualUrlStr = strings.Replace(artichoke.Url, "https://maven.minecraftforge.net/", mirror, 1)
-				actualUrlStr = GetMirrorFor(artichoke.Url, mirror)
-				if FileOnServer(actualUrlStr) {
-					break Out
-				}
-			}
-			actualUrl, err := url.Parse(actualUrlStr)
-			if err != nil {
+			mirrors := MirrorsFor(artichoke.Url, forgeMaven, "")
+			if len(mirrors) == 0 {
 				continue
 			}
-			downloads = append(downloads, Download{filepath.Join("libraries", dir), *actualUrl, file, "sha1", artichoke.SHA1, filepath.Join("libraries", dir, file)})
+			downloads = append(downloads, Download{filepath.Join("libraries", dir), mirrors[0], file, "sha1", artichoke.SHA1, filepath.Join("libraries", dir, file), mirrors[1:], artichoke.Size})
 		}
 	}
 	return downloads
@@ -562,6 +570,7 @@ type VersionLibraryFG3 struct {
 			Path string `json:"path"`
 			Url  string `json:"url"`
 			SHA1 string `json:"sha1"`
+			Size int64  `json:"size"`
 		} `json:"artifact"`
 	} `json:"downloads"`
 }
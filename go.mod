@@ -3,6 +3,8 @@ module ftbserverdownloader
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/cavaliergopher/grab/v3 v3.0.1
 	github.com/hashicorp/go-version v1.6.0
+	github.com/klauspost/compress v1.16.7
 )
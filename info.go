@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+type infoVersion struct {
+	Version
+	Targets []Target `json:"targets"`
+}
+
+type infoOutput struct {
+	ID       int          `json:"id"`
+	Name     string       `json:"name"`
+	Channel  string       `json:"channel"`
+	Versions []Version    `json:"versions"`
+	Selected *infoVersion `json:"selected,omitempty"`
+}
+
+func formatUpdated(updated int) string {
+	if updated == 0 {
+		return "unknown"
+	}
+	return time.Unix(int64(updated), 0).UTC().Format("2006-01-02 15:04")
+}
+
+func runInfo(ctx context.Context, filename string, args []string) {
+	err, packId, versionId := parseIds(args)
+	if err == nil && packId == -1 {
+		err = fmt.Errorf("missing modpack id")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		PrintCommandUsage(filename, findCommand("info"))
+		os.Exit(2)
+	}
+	if versionId == -1 {
+		versionId = -2
+	}
+	if Options.Output == "json" {
+		loggerOut = os.Stderr
+	}
+
+	err, modpack := GetModpack(ctx, packId)
+	if err != nil {
+		fatalf("Error fetching modpack: %v\n", err)
+	}
+
+	out := infoOutput{ID: modpack.ID, Name: modpack.Name, Channel: Options.Channel, Versions: []Version{}}
+	for _, v := range modpack.Versions {
+		if v.InChannel(Options.Channel) {
+			out.Versions = append(out.Versions, v)
+		}
+	}
+	sort.SliceStable(out.Versions, func(i, j int) bool {
+		return out.Versions[i].Updated > out.Versions[j].Updated
+	})
+
+	err, versionInfo := modpack.GetVersion(ctx, versionId)
+	if err != nil {
+		printfln("Unable to fetch version details: %v", err)
+	} else if versionInfo.Version != nil {
+		out.Selected = &infoVersion{*versionInfo.Version, versionInfo.Targets}
+	}
+
+	if Options.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return
+	}
+
+	fmt.Printf("%s (%d) - %s channel\n\n", out.Name, out.ID, out.Channel)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tUPDATED\tMIN MEMORY\tRECOMMENDED MEMORY")
+	for _, v := range out.Versions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%dM\t%dM\n", v.ID, v.Name, v.Type, formatUpdated(v.Updated), v.Specs.Minimum, v.Specs.Recommend)
+	}
+	w.Flush()
+
+	if out.Selected != nil {
+		fmt.Printf("\nTargets for %s (%d):\n", out.Selected.Name, out.Selected.ID)
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TYPE\tNAME\tVERSION")
+		for _, target := range out.Selected.Targets {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", target.Type, target.Name, target.Version)
+		}
+		w.Flush()
+	}
+}
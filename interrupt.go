@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// exitInterrupted is the exit code after Ctrl-C or SIGTERM, the same as shells
+// use for SIGINT.
+const exitInterrupted = 130
+
+// interruptGrace is how long work has to stop and clean up after a signal
+// before the installer exits regardless.
+const interruptGrace = 10 * time.Second
+
+var interrupted int32
+
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) == 1
+}
+
+// interruptContext is cancelled on SIGINT or SIGTERM. Whatever is running then
+// gets interruptGrace to stop, and a second signal exits straight away.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		atomic.StoreInt32(&interrupted, 1)
+		printfln("Interrupted, stopping...")
+		cancel()
+		select {
+		case <-signals:
+		case <-time.After(interruptGrace):
+		}
+		exit(exitInterrupted, "interrupted")
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// stopIfInterrupted exits if ctx was cancelled by a signal, for use between
+// steps that carry on after errors.
+func stopIfInterrupted(ctx context.Context) {
+	if ctx.Err() != nil && isInterrupted() {
+		exit(exitInterrupted, "interrupted")
+	}
+}
@@ -1,33 +1,27 @@
 package main
 
 import (
-	"crypto"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/cavaliergopher/grab/v3"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
-	"reflect"
 	"regexp"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var client = &http.Client{}
-
 const BaseAPIURL = "https://api.modpacks.ch/"
 const BaseModpackURL = BaseAPIURL + "%s/modpack/"
 const BaseCurseforgeURL = BaseAPIURL + "public/curseforge/"
-const SearchURL = BaseModpackURL + "search/5?term="
+const SearchURL = BaseModpackURL + "search/%d?term=%s"
+const CurseforgeSearchURL = BaseCurseforgeURL + "search/%d?term=%s"
 
 var (
 	verStr    = "dev"
@@ -37,20 +31,52 @@ var (
 	inProgress = 0
 	succeeded  = 0
 	failed     = 0
+	retried    = 0
+
+	failedTransfers []*Transfer
 )
 
 var Options struct {
-	Auto            bool   `help:"Ask no questions, use defaults."`
-	Path            string `help:"Directory to install in. Default: current directory"`
-	Noscript        bool   `help:"Skip creating start script. Default: false"`
-	Nojava          bool   `help:"Skip downloading a compatible Adoptium JRE. Default: false"`
-	Threads         int    `help:"Number of threads to use for downloading. Default: cpucores * 2"`
-	Integrityupdate bool   `help:"Whether changed files should be overwritten with fresh copies when updating. Most useful when used with Auto. Default: false\n    Example: You changed config/test.cfg on your server from default. The modpack updates config/test.cfg - with this flag, it will assume you wish to overwrite with the latest version"`
-	Integrity       bool   `help:"Do a full integrity check, even on files not changed by the update. integrityupdate assumed. Default: true"`
-	Verbose         bool   `help:"Be a bit noisier on actions taken. Default: false"`
-	Latest          bool   `help:"Install latest, ignoring any version in the file name or arguments. Default: false"`
-	Curseforge      bool   `help:"Specifies that pack is a Curseforge modpack"`
-	Help            bool   `help:"This help"`
+	Auto            bool     `flag:"auto" short:"a" cmd:"install,update,repair,uninstall,clean,bundle,rollback" help:"Ask no questions, use defaults."`
+	Path            string   `flag:"path" short:"p" cmd:"install,update,verify,repair,uninstall,clean,config,rollback" help:"Directory to install in. Default: current directory"`
+	Noscript        bool     `flag:"noscript" cmd:"install,update" help:"Skip creating start script. Default: false"`
+	Nojava          bool     `flag:"nojava" cmd:"install,update,repair,bundle" help:"Skip downloading a compatible Adoptium JRE. Default: false"`
+	Threads         int      `flag:"threads" short:"t" cmd:"install,update,repair,bundle" help:"Number of threads to use for downloading. Default: cpucores * 2"`
+	Retries         int      `flag:"retries" cmd:"install,update,repair,bundle" help:"Times to retry a download that failed with a connection error, bad checksum or a 408, 429 or 5xx status. Default: 4"`
+	Limitrate       string   `flag:"limit-rate" cmd:"install,update,repair,bundle" help:"Limit the total download speed of all threads, in bytes per second with an optional K, M or G suffix, e.g. 2M. Default: no limit"`
+	Maxperhost      int      `flag:"max-per-host" cmd:"install,update,repair,bundle" help:"Maximum number of downloads from the same host at once. Default: no limit"`
+	Integrityupdate bool     `flag:"integrityupdate" cmd:"install,update" help:"Whether changed files should be overwritten with fresh copies when updating. Most useful when used with Auto. Default: false\n    Example: You changed config/test.cfg on your server from default. The modpack updates config/test.cfg - with this flag, it will assume you wish to overwrite with the latest version"`
+	Integrity       bool     `flag:"integrity" cmd:"install,update" help:"Do a full integrity check, even on files not changed by the update. integrityupdate assumed. Default: true"`
+	Verbose         bool     `flag:"verbose" short:"v" help:"Be a bit noisier on actions taken. Default: false"`
+	Latest          bool     `flag:"latest" short:"l" cmd:"install,update,bundle" help:"Install the latest version in the selected channel, ignoring any version in the file name or arguments. Default: false"`
+	Curseforge      bool     `flag:"curseforge" short:"c" help:"Specifies that pack is a Curseforge modpack"`
+	Dryrun          bool     `flag:"dry-run,dryrun" short:"n" cmd:"install,update,repair,uninstall,clean" help:"Work out and print what an install, update, repair or uninstall would do without writing anything. Default: false"`
+	Output          string   `flag:"output" short:"o" help:"Output format for reports and install progress, text or json. Default: text"`
+	Limit           int      `flag:"limit" cmd:"search" help:"Maximum number of search results. Default: 5"`
+	Channel         string   `flag:"channel" cmd:"install,update,info,search,bundle" help:"Release channel to pick the latest version from: release, beta or alpha. beta includes releases and alpha includes everything. Default: release"`
+	Apikey          string   `flag:"apikey" help:"Private API key to use instead of the one in the binary. Default: public"`
+	Mirrors         []string `flag:"mirrors" cmd:"install,update,repair,bundle" help:"Comma separated list of Maven mirrors to try before the built in ones, for every mod loader. Mirrors can be URLs or local folders."`
+	Forgemirrors    []string `flag:"forge-mirrors" cmd:"install,update,repair,bundle" help:"Maven mirrors to try first for Forge and its libraries."`
+	Neoforgemirrors []string `flag:"neoforge-mirrors" cmd:"install,update,repair,bundle" help:"Maven mirrors to try first for NeoForge and its libraries."`
+	Fabricmirrors   []string `flag:"fabric-mirrors" cmd:"install,update,repair,bundle" help:"Maven mirrors to try first for Fabric libraries."`
+	Mojangmirrors   []string `flag:"mojang-mirrors" cmd:"install,update,repair,bundle" help:"Maven mirrors to try first for Mojang libraries."`
+	Mirrorsonly     bool     `flag:"mirrors-only" cmd:"install,update,repair,bundle" help:"Don't fall back to the built in Maven repositories for anything that has mirrors configured. Default: false"`
+	Xmx             int      `flag:"xmx" cmd:"install,update" help:"Maximum memory in MB for the start script. Default: the pack's recommended memory"`
+	Xms             int      `flag:"xms" cmd:"install,update" help:"Initial memory in MB for the start script. Default: the pack's minimum memory"`
+	Jvmargs         string   `flag:"jvmargs" cmd:"install,update" help:"Extra JVM arguments for the start script."`
+	Cachedir        string   `flag:"cache-dir" help:"Folder downloads are cached in by hash and shared between installs. Default: modpacksch in the user cache folder"`
+	Nocache         bool     `flag:"no-cache,nocache" cmd:"install,update,repair,bundle" help:"Don't use or fill the download cache. Default: false"`
+	Maxsize         string   `flag:"max-size" cmd:"cache" help:"For cache prune, remove least recently used files until the cache is at most this size, e.g. 10G"`
+	Maxage          string   `flag:"max-age" cmd:"cache" help:"For cache prune, remove files not used for this long, e.g. 30d or 12h"`
+	Frombundle      string   `flag:"from-bundle" cmd:"install,update" help:"Install from a bundle made by the bundle command, without any network access."`
+	Bundlefile      string   `flag:"file" short:"f" cmd:"bundle" help:"File to write the bundle to, compressed with zstd, or with gzip if it ends in .gz or .tgz, or not at all if it ends in .tar. Default: bundle_<modpackid>_<versionid>.tar.zst"`
+	Mergeconflicts  string   `flag:"merge-conflicts" cmd:"install,update" help:"How to leave a config that both you and the update changed when the changes conflict: markers writes conflict markers into it, rej keeps your lines and writes the conflicts to a .rej file next to it. Configs are only merged without integrityupdate. Default: markers"`
+	Backups         int      `flag:"backups" cmd:"install,update" help:"Number of snapshots taken before updates to keep for the rollback command. 0 turns them off. Default: 3"`
+	Timeout         int      `flag:"timeout" help:"Seconds to wait to connect, for a response or for more data before a request fails. 0 waits forever. Default: 30"`
+	Proxy           string   `flag:"proxy" help:"Proxy to send every request through, e.g. http://proxy:3128 or socks5://proxy:1080. Hosts in NO_PROXY are still reached directly. Default: HTTPS_PROXY or HTTP_PROXY from the environment"`
+	Cacert          string   `flag:"cacert" help:"PEM file of extra CA certificates to trust, such as those of an intercepting proxy. Default: only the system certificates"`
+	Config          string   `flag:"config" config:"-" help:"Config file to read options from. Default: serverdownloader.toml in the install path and in $XDG_CONFIG_HOME"`
+	Help            bool     `flag:"help" short:"h" config:"-" help:"This help"`
 }
 
 var markerBytes = []byte("~~I'm not a bad downloader, slurp!~~")
@@ -70,138 +96,39 @@ func main() {
 	Options.Path = ""
 	Options.Noscript = false
 	Options.Threads = runtime.NumCPU() * 2
+	Options.Retries = 4
+	Options.Timeout = 30
+	Options.Backups = 3
+	Options.Mergeconflicts = "markers"
 	Options.Integrityupdate = false
 	Options.Verbose = false
 	Options.Integrity = true
 	Options.Latest = false
 	Options.Curseforge = false
+	Options.Dryrun = false
+	Options.Output = "text"
+	Options.Limit = 5
+	Options.Channel = "release"
 
 	Options.Help = false
 
-	parsed := make(map[string]string)
-
-	var flag string
-
-	packIdFound := -1
-	versionFound := -1
-
-	for i, arg := range os.Args {
-		if i == 0 {
-			continue
-		}
-		if i == 1 {
-			tempPack, err := strconv.Atoi(arg)
-			if err == nil {
-				packIdFound = tempPack
-				versionFound = -2
-			}
-		}
-		if i == 2 {
-			if packIdFound > 0 {
-				tempVer, err := strconv.Atoi(arg)
-				if err == nil {
-					versionFound = tempVer
-				}
-			}
-		}
-		if strings.HasPrefix(arg, "--") {
-			if len(flag) > 0 {
-				parsed[flag] = "true"
-				flag = ""
-				flag = arg[2:]
-			} else {
-				flag = arg[2:]
-			}
-			continue
-		} else {
-			if len(flag) > 0 {
-				parsed[flag] = arg
-				flag = ""
-			}
-		}
-	}
-
-	if len(flag) > 0 {
-		parsed[flag] = "true"
-		flag = ""
-	}
-
-	for name, val := range parsed {
-		v := reflect.ValueOf(&Options).Elem().FieldByName(strings.Title(name))
-		if v.IsValid() {
-			fieldType := v.Type().String()
-			switch fieldType {
-			case "bool":
-				v.SetBool(val == "true")
-				break
-			case "string":
-				v.SetString(val)
-				break
-			case "int64":
-				val, ok := strconv.ParseInt(val, 10, 64)
-				if ok != nil {
-					v.SetInt(val)
-				}
-			}
-		}
-	}
-
-	if Options.Help == true {
-		PrintUsage(filename)
-		os.Exit(0)
-	}
+	RunCommand(filename, os.Args[1:])
+}
 
-	if Options.Latest {
-		versionFound = -2
+func printHeader() {
+	if Options.Output == "json" {
+		// Keep stdout clean for the JSON document.
+		loggerOut = os.Stderr
 	}
 
-	fmt.Println(fmt.Sprintf("Server installer version %s commit %s", verStr, commitStr))
+	fmt.Fprintln(loggerOut, fmt.Sprintf("Server installer version %s commit %s", verStr, commitStr))
 	currentUser, err := user.Current()
 	if err == nil {
-		fmt.Println(fmt.Sprintf("Running installer as user %s (%s)", currentUser.Username, currentUser.Uid))
-	}
-	HandleLaunch(filename, packIdFound, versionFound)
-}
-
-func PrintUsage(filename string) {
-	err, modpackID, versionID := ParseFilename(filename)
-
-	println("                      _                  _              _     ")
-	println("                     | |                | |            | |    ")
-	println("  _ __ ___   ___   __| |_ __   __ _  ___| | _____   ___| |__  ")
-	println(" | '_ ` _ \\ / _ \\ / _` | '_ \\ / _` |/ __| |/ / __| / __| '_ \\ ")
-	println(" | | | | | | (_) | (_| | |_) | (_| | (__|   <\\__ \\| (__| | | |")
-	println(" |_| |_| |_|\\___/ \\__,_| .__/ \\__,_|\\___|_|\\_\\___(_)___|_| |_|")
-	println("                       | |                                    ")
-	println("                       |_|                                    ")
-	println(" modpacks.ch server downloader golang - build " + verStr)
-	println(" based on commit " + commitStr)
-	println()
-	println("Usage:")
-	if err == nil {
-		fmt.Printf("  "+filename+" - without arguments will install modpack ID %d with version %d\n", modpackID, versionID)
-		fmt.Printf("  "+filename+" --latest - will install modpack ID %d with the latest version available\n", modpackID, versionID)
-	}
-	println("  " + filename + " <modpackid> <versionid> - will install the modpack specified by <modpackid> with the version specified by <versionid>")
-	println("  " + filename + " <modpackid> - will install the modpack specified by <modpackid> with the latest version available")
-	println()
-	println("Arguments:")
-
-	t := reflect.ValueOf(Options)
-	for i := 0; i < t.NumField(); i++ {
-		name := strings.ToLower(t.Type().Field(i).Name)
-		println("  --"+name, "-", t.Type().Field(i).Tag.Get("help"))
+		fmt.Fprintln(loggerOut, fmt.Sprintf("Running installer as user %s (%s)", currentUser.Username, currentUser.Uid))
 	}
 }
 
-/*func Search(term string) []Modpack {
-	termSafe := url.QueryEscape(term)
-	result := SearchResult{}
-	APICall(SearchURL + termSafe, *result)
-	return []Modpack{}
-}*/
-
-func HandleLaunch(file string, found int, versionFound int) {
+func HandleLaunch(ctx context.Context, file string, found int, versionFound int) {
 	err, modpackId, versionId := ParseFilename(file)
 	if err != nil {
 		if found == -1 {
@@ -229,6 +156,23 @@ func HandleLaunch(file string, found int, versionFound int) {
 	if len(installPath) == 0 || installPath[0] != "/"[0] {
 		installPath = filepath.Join(".", installPath)
 	}
+
+	if !Options.Dryrun {
+		ResumeUpdate(installPath)
+	}
+
+	err, plan := BuildPlan(ctx, modpackId, versionId, installPath)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if Options.Dryrun {
+		if err := plan.Print(os.Stdout); err != nil {
+			fatalf("Error writing plan: %v", err)
+		}
+		exit(0, "")
+	}
+
 	if _, err := os.Stat(installPath); os.IsNotExist(err) {
 		LogIfVerbose("Making folder %s\n", installPath)
 		if err := os.MkdirAll(installPath, os.FileMode(0755)); err != nil {
@@ -240,241 +184,139 @@ func HandleLaunch(file string, found int, versionFound int) {
 		}
 
 	}
-	upgrade := false
-	if _, err := os.Stat(filepath.Join(installPath, "version.json")); !os.IsNotExist(err) {
-		upgrade = true
-	}
-
-	err, modpack := GetModpack(modpackId)
-	if err != nil {
-		fatalf("Error fetching modpack: %v", err)
-	}
 
-	err, versionInfo := modpack.GetVersion(versionId)
-	if err != nil {
-		fatalf("Error fetching modpack: %v", err)
-	}
-
-	downloads = versionInfo.GetDownloads()
+	modpack := plan.Modpack
+	versionInfo := plan.VersionInfo
 
 	upgradeStr := ""
 
-	if upgrade {
+	if plan.Upgrade {
 		upgradeStr = " as an update"
+		if plan.Previous.Version != nil && plan.Previous.Updated > versionInfo.Updated {
+			printfln("Installed version %s is newer than %s version %s", plan.Previous.Name, Options.Channel, versionInfo.Name)
+		}
 	}
 
 	if !QuestionYN(true, "Continuing will install %s version %s%s. Do you wish to continue?", modpack.Name, versionInfo.Name, upgradeStr) {
 		fatalf("Aborted by user")
 	}
 
-	if upgrade {
-		err, info := GetVersionInfoFromFile(filepath.Join(installPath, "version.json"))
-		if err != nil {
-			if !QuestionYN(true, "An error occurred whilst trying to read the previous installation at %s: %v\nWould you like to continue anyway? You should probably delete folders with mods and configs in it, first!", installPath, err) {
+	if plan.Upgrade {
+		if plan.PreviousErr != nil {
+			if !QuestionYN(true, "An error occurred whilst trying to read the previous installation at %s: %v\nWould you like to continue anyway? You should probably delete folders with mods and configs in it, first!", installPath, plan.PreviousErr) {
 				fatalf("Aborting due to corrupted previous installation")
 			} else {
 				// TODO: handle removing folders here
 			}
 		}
 
-		if info.ParentId != modpack.ID {
+		if plan.Previous.ParentId != modpack.ID {
 			if !QuestionYN(true, "Previous modpack is different to this modpack\nWould you like to continue anyway? You should probably delete folders with mods and configs in it, first!") {
 				fatalf("Aborting due to different modpack already installed")
 			}
 		}
 
-		oldDownloads := info.GetDownloads()
-		getSortFunc := func(arr []Download) func(i int, j int) bool {
-			return func(i int, j int) bool {
-				return arr[i].FullPath < arr[j].FullPath
-			}
+		files := plan.Files
+		printfln("This install has %v files changed, %v new files, %v missing files and %v deleted files", len(files.Changed)+len(files.ModifiedUpdates()), len(files.Added), len(files.Missing), len(files.Deleted))
+		runSummary.Changed = len(files.Changed) + len(files.ModifiedUpdates())
+		runSummary.New = len(files.Added)
+		runSummary.Missing = len(files.Missing)
+		runSummary.Deleted = len(files.Deleted)
+
+		runSummary.Protected = protectedList(plan.Protected)
+		downloads = files.Downloads(false)
+		merges, modified := MergeCandidates(installPath, files.ModifiedUpdates())
+		if len(merges) > 0 {
+			printfln("%v locally modified config files will be merged with the update", len(merges))
+			downloads = append(downloads, newFiles(merges)...)
 		}
-
-		sort.SliceStable(oldDownloads, getSortFunc(oldDownloads))
-		sort.SliceStable(downloads, getSortFunc(downloads))
-
-		lastFound := -1
-
-		downloadsLen := len(downloads)
-
-		var changedFilesOld []Download
-		var changedFilesNew []Download
-
-		var newFiles []Download
-		var oldDeletedFiles []Download
-		var integrityFailures []Download
-
-		mcCleanup(installPath)
-
-		for _, oldDown := range oldDownloads {
-			for i := lastFound + 1; i < downloadsLen; i++ {
-				newDown := downloads[i]
-				if oldDown.FullPath == newDown.FullPath {
-					lastFound = i
-					if oldDown.HashType != newDown.HashType || oldDown.Hash != newDown.Hash {
-						changedFilesOld = append(changedFilesOld, oldDown)
-						changedFilesNew = append(changedFilesNew, newDown)
-						LogIfVerbose("Found changed file %s\n", newDown.FullPath)
-					} else if Options.Integrity {
-						LogIfVerbose("Checking integrity of file %s\n", newDown.FullPath)
-						if !newDown.VerifyChecksum(installPath) {
-							integrityFailures = append(integrityFailures, oldDown)
-						}
-					}
-					break
-				}
-				if newDown.FullPath > oldDown.FullPath {
-					lastFound = i - 1
-					oldDeletedFiles = append(oldDeletedFiles, oldDown)
-					LogIfVerbose("Found deleted file %s\n", newDown.FullPath)
-					break
-				}
-				newFiles = append(newFiles, newDown)
-				LogIfVerbose("Found new file %s\n", newDown.FullPath)
-			}
-		}
-
-		printfln("This install has %v files changed, %v new files and %v deleted files", len(changedFilesOld), len(newFiles), len(oldDeletedFiles))
-
-		var failedChecksums []Download
-
-		for _, oldDown := range changedFilesOld {
-			if !oldDown.VerifyChecksum(installPath) {
-				failedChecksums = append(failedChecksums, oldDown)
-				LogIfVerbose("Detected failed checksum on %s\n", oldDown.FullPath)
-			}
-		}
-
-		if len(failedChecksums) > 0 {
-			overwrite := QuestionYN(Options.Integrityupdate || Options.Integrity, "There are %v failed checksums on files to be updated. This may be as a result of manual config changes. Do you wish to overwrite them with the files from the update?", failedChecksums)
+		plan.Merges = merges
+		if len(modified) > 0 {
+			overwrite := QuestionYN(Options.Integrityupdate || Options.Integrity, "There are %v failed checksums on files to be updated. This may be as a result of manual config changes. Do you wish to overwrite them with the files from the update?", len(modified))
 			if overwrite {
-				for i := range failedChecksums {
-					changedFilesNew[i] = changedFilesNew[len(changedFilesNew)-1]
-				}
-				changedFilesNew = changedFilesNew[:len(changedFilesNew)-len(failedChecksums)]
+				downloads = append(downloads, newFiles(modified)...)
 			}
 		}
-
-		if len(integrityFailures) > 0 {
-			overwrite := QuestionYN(true, "There are %v failed checksums on already existing files. This may be as a result of manual config changes. Do you wish to overwrite them with the files from the update?", failedChecksums)
+		if modified := files.ModifiedUnchanged(); len(modified) > 0 {
+			overwrite := QuestionYN(true, "There are %v failed checksums on already existing files. This may be as a result of manual config changes. Do you wish to overwrite them with the files from the update?", len(modified))
 			if overwrite {
-				changedFilesNew = append(changedFilesNew, integrityFailures...)
+				downloads = append(downloads, newFiles(modified)...)
 			}
 		}
+	} else {
+		downloads = plan.Files.Added
+		runSummary.New = len(plan.Files.Added)
+		runSummary.Protected = protectedList(plan.Protected)
+	}
 
-		downloads = append(changedFilesNew, newFiles...)
+	ml := plan.ModLoader
+	java := plan.Java
+	// Updates are staged, and only applied once everything is in place.
+	workPath := plan.WorkPath
 
-		printfln("Deleting removed files...")
-		for _, down := range oldDeletedFiles {
-			filePath := filepath.Join(installPath, down.FullPath)
-			LogIfVerbose("Removing %s\n", filePath)
-			if os.Remove(filePath) != nil {
-				printfln("Error occurred whilst removing file " + filePath)
-				continue
-			}
-			tempPath := filepath.Join(installPath, down.Path)
-			dir, err := os.Open(tempPath)
-			empty := false
-			if err == nil {
-				empty = true
-				names, _ := dir.Readdirnames(-1)
-				for _, name := range names {
-					if name != "." && name != ".." {
-						empty = false
-						break
-					}
-				}
-			}
-			if empty {
-				LogIfVerbose("Removing %s as is empty\n", tempPath)
-				if os.RemoveAll(tempPath) != nil {
-					printfln("Error occurred whilst removing folder " + tempPath)
-				}
-			}
-		}
+	downloads = append(downloads, plan.ExtraDownloads...)
+	downloads = append(downloads, plan.ModLoaderDownloads...)
+	downloads = append(downloads, plan.JavaDownloads...)
 
+	if plan.Upgrade {
+		if err := BeginStaging(installPath, versionInfo); err != nil {
+			fatalf("Unable to prepare the staging folder %s: %v", workPath, err)
+		}
 		printfln("Performing update...")
 	} else {
 		printfln("Performing installation...")
 	}
 
-	err, ml := versionInfo.GetModLoader()
-
-	if err != nil {
-		fatalf("Error getting Modloader: %v", err)
-	}
-
-	modLoaderDls := ml.GetDownloads(installPath)
-
-	URL, _ := url.Parse("https://media.forgecdn.net/files/3557/251/Log4jPatcher-1.0.0.jar")
-	downloads = append(downloads, Download{"log4jfix/", *URL, "Log4jPatcher-1.0.0.jar", "sha1", "eb20584e179dc17b84b6b23fbda45485cd4ad7cc", filepath.Join("log4jfix/", "Log4jPatcher-1.0.0.jar")})
-
-	downloads = append(downloads, modLoaderDls...)
-
-	var java JavaProvider
-	if Options.Nojava {
-		java = &NoOpJavaProvider{}
+	CheckDiskSpace(installPath, RequiredSpace(downloads, plan.JavaDownloads))
+	if plan.Upgrade {
+		downloadAll(ctx, workPath)
+		stopIfInterrupted(ctx)
+		if failed > 0 {
+			printfln("Nothing was changed, run the update again to retry the failed downloads")
+			exit(failed, "some downloads failed")
+		}
 	} else {
-		java = versionInfo.GetJavaProvider()
-	}
-
-	downloads = append(downloads, java.GetDownloads(installPath)...)
-
-	grabs, err := GetBatch(Options.Threads, installPath, downloads...)
-	if err != nil {
-		fatal(err)
-	}
-	responses := make([]*grab.Response, 0, len(downloads))
-	t := time.NewTicker(200 * time.Millisecond)
-	defer t.Stop()
-
-Loop:
-	for {
-		select {
-		case resp := <-grabs:
-			if resp != nil {
-				// a new response has been received and has started downloading
-				responses = append(responses, resp)
-			} else {
-				// channel is closed - all downloads are complete
-				updateUI(responses)
-				break Loop
-			}
-
-		case <-t.C:
-			// update UI every 200ms
-			updateUI(responses)
+		DownloadAll(ctx, installPath)
+		if err := SavePristine(installPath, installPath, plan.Files.Added); err != nil {
+			printfln("Unable to keep pristine copies of the pack's configs, they won't be merged on update: %v", err)
 		}
 	}
 
-	printf(
-		"Downloaded %d successful, %d failed, %d incomplete.\n",
-		succeeded,
-		failed,
-		inProgress,
-	)
-
-	if failed > 0 {
-		if !QuestionYN(true, "Some downloads failed. Would you like to continue anyway?") {
-			os.Exit(failed)
-		}
+	emitPhase("java")
+	if !java.Install(ctx, workPath) && plan.Upgrade {
+		stopIfInterrupted(ctx)
+		fatalf("Unable to install Java, nothing was changed")
 	}
-
-	java.Install(installPath)
+	stopIfInterrupted(ctx)
 
 	time.Sleep(time.Second * 2)
 
-	ml.Install(installPath, java)
+	emitPhase("modloader")
+	if !ml.Install(ctx, workPath, java) && plan.Upgrade {
+		stopIfInterrupted(ctx)
+		fatalf("Unable to install the mod loader, nothing was changed")
+	}
+	stopIfInterrupted(ctx)
 
-	versionInfo.WriteJson(installPath)
+	if !versionInfo.WriteJson(ctx, workPath) && plan.Upgrade {
+		stopIfInterrupted(ctx)
+		fatalf("Unable to write version.json, nothing was changed")
+	}
 
 	if !Options.Noscript {
-		versionInfo.WriteStartScript(installPath, ml, java)
+		emitPhase("script")
+		versionInfo.WriteStartScript(workPath, ml, java)
+	}
+
+	if plan.Upgrade {
+		applyStaged(installPath, plan, downloads)
 	}
+
 	if Options.Curseforge {
-		err = extractZip(installPath, filepath.Join(installPath, "overrides.zip"))
+		emitPhase("overrides")
+		err = extractZip(ctx, installPath, filepath.Join(installPath, "overrides.zip"))
 		if err != nil {
-			fatalf("Error extracting overrides.zip\n", err)
+			fatalf("Error extracting overrides.zip: %v\n", err)
 		}
 		err := filepath.Walk(filepath.Join(installPath, "overrides"), func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -485,7 +327,7 @@ Loop:
 				if _, err = os.Stat(strippedPath); errors.Is(err, os.ErrNotExist) {
 					err = os.Mkdir(strippedPath, os.ModePerm)
 					if err != nil {
-						fatalf("Error creating directory\n", err)
+						fatalf("Error creating directory: %v\n", err)
 						return err
 					}
 				}
@@ -493,7 +335,7 @@ Loop:
 			}
 			err = os.Rename(path, strippedPath)
 			if err != nil {
-				fatalf("Error moving file from overrides\n", err)
+				fatalf("Error moving file from overrides: %v\n", err)
 				return err
 			}
 			return nil
@@ -506,10 +348,11 @@ Loop:
 		os.RemoveAll(filepath.Join(installPath, "overrides"))
 	}
 
+	printMerges()
+	printProtected(runSummary.Protected)
 	printfln("Installed!")
 
-	// return the number of failed downloads as exit code
-	os.Exit(0)
+	exit(0, "")
 }
 
 func ParseFilename(file string) (error, int, int) {
@@ -529,7 +372,7 @@ func ParseFilename(file string) (error, int, int) {
 	return nil, modpackId, versionId
 }
 
-func (v VersionInfo) GetModLoader() (error, ModLoader) {
+func (v VersionInfo) GetModLoader(ctx context.Context) (error, ModLoader) {
 	var ret ModLoader
 	var modLoader Target
 	var minecraftTar Target
@@ -553,11 +396,11 @@ func (v VersionInfo) GetModLoader() (error, ModLoader) {
 	}
 
 	if modLoader.Name == "forge" {
-		return GetForge(modLoader, mc)
+		return GetForge(ctx, modLoader, mc)
 	} else if modLoader.Name == "neoforge" {
-		return GetNeoForge(modLoader, mc)
+		return GetNeoForge(ctx, modLoader, mc)
 	} else if modLoader.Name == "fabric" {
-		return GetFabric(modLoader, mc)
+		return GetFabric(ctx, modLoader, mc)
 	}
 	return errors.New(fmt.Sprintf("Unable to locate Mod Loader for %s %s %s", modLoader.Name, modLoader.Version, mc.RawVersion)), ret
 }
@@ -573,8 +416,8 @@ func (v VersionInfo) GetJavaProvider() JavaProvider {
 	return &AdoptiumJavaProvider{&splits[0], target, nil}
 }
 
-func APICall(url string, val interface{}) error {
-	req, err := http.NewRequest("GET", url, nil)
+func APICall(ctx context.Context, url string, val interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -602,63 +445,109 @@ func APICall(url string, val interface{}) error {
 	return nil
 }
 
-func GetBatch(workers int, dst string, downloads ...Download) (<-chan *grab.Response, error) {
-	fi, err := os.Stat(dst)
-	if err != nil {
-		return nil, err
+// DownloadAll fetches everything in downloads into installPath, reporting
+// progress as it goes, and asks whether to carry on if anything failed.
+func DownloadAll(ctx context.Context, installPath string) {
+	downloadAll(ctx, installPath)
+	stopIfInterrupted(ctx)
+	if failed > 0 {
+		if !QuestionYN(true, "Some downloads failed. Would you like to continue anyway?") {
+			// return the number of failed downloads as exit code
+			exit(failed, "some downloads failed")
+		}
 	}
-	if !fi.IsDir() {
-		return nil, fmt.Errorf("destination is not a directory")
+}
+
+// downloadAll fetches everything in downloads into installPath, reporting
+// progress and failures as it goes.
+func downloadAll(ctx context.Context, installPath string) {
+	emitPhase("download")
+	grabs, err := GetBatch(ctx, Options.Threads, installPath, downloads...)
+	if err != nil {
+		fatal(err)
 	}
+	transfers := make([]*Transfer, 0, len(downloads))
+	t := time.NewTicker(200 * time.Millisecond)
+	defer t.Stop()
 
-	reqs := make([]*grab.Request, len(downloads))
-	for i := 0; i < len(downloads); i++ {
-		download := downloads[i]
-		tmpPath := download.Path
-		if !filepath.IsAbs(tmpPath) {
-			tmpPath = filepath.Join(dst, tmpPath)
-		}
-		grab.DefaultClient.UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36 Edg/116.0.1938.69"
-		req, err := grab.NewRequest(filepath.Join(tmpPath, download.Name), download.URL.String())
-		if err != nil {
-			return nil, err
-		}
-		req.NoResume = true // force re-download
-		// TODO, Download should have a function to get the 'validation properties'
-		//  this could unify some hash handling.
-		if len(download.HashType) != 0 && len(download.Hash) != 0 {
-			byteHex, _ := hex.DecodeString(download.Hash)
-			hashType := crypto.SHA1 // Ideally i want null default.
-			switch download.HashType {
-			case "sha1":
-				hashType = crypto.SHA1
-			case "sha256":
-				hashType = crypto.SHA256
+Loop:
+	for {
+		select {
+		case t := <-grabs:
+			if t != nil {
+				// a new transfer has been received and has started downloading
+				transfers = append(transfers, t)
+				emit(Event{Event: "started", File: t.Filename, URL: t.URL()})
+			} else {
+				// channel is closed - all downloads are complete
+				updateUI(transfers)
+				break Loop
 			}
-			req.SetChecksum(hashType.New(), byteHex, false)
-		}
 
-		reqs[i] = req
+		case <-t.C:
+			// update UI every 200ms
+			updateUI(transfers)
+		}
 	}
 
-	ch := grab.DefaultClient.DoBatch(workers, reqs...)
-	return ch, nil
+	printf(
+		"Downloaded %d successful, %d failed, %d incomplete.\n",
+		succeeded,
+		failed,
+		inProgress,
+	)
+	if retried > 0 {
+		printfln("%d downloads only succeeded after retrying", retried)
+	}
+	for _, t := range failedTransfers {
+		printfln("Failed %s after %d attempts, last error: %v", t.Filename, t.Attempts(), t.Err())
+		runSummary.Failures = append(runSummary.Failures, FailedDownload{t.Filename, t.URL(), t.Attempts(), t.Err().Error()})
+	}
 }
 
-func updateUI(responses []*grab.Response) {
+// lastProgress is when progress events were last emitted, so they go out
+// about once a second rather than on every UI update.
+var lastProgress time.Time
+
+func updateUI(transfers []*Transfer) {
 	// print newly completed downloads
-	for i, resp := range responses {
-		if resp != nil && resp.IsComplete() {
-			if resp.Err() != nil {
+	for i, t := range transfers {
+		if t != nil && t.IsComplete() {
+			resp := t.Response()
+			if t.Err() != nil {
 				failed++
-				printf("Error downloading %s: %v\n",
-					resp.Request.URL(),
-					resp.Err())
+				failedTransfers = append(failedTransfers, t)
+				printf("Error downloading %s after %d attempts: %v\n",
+					t.URL(),
+					t.Attempts(),
+					t.Err())
+				emit(Event{Event: "failed", File: t.Filename, URL: t.URL(), Attempt: t.Attempts(), Error: t.Err().Error()})
+			} else if t.Cached() {
+				succeeded++
+				printf("[%d/%d] Copied %s from cache\n", succeeded, len(downloads), t.Filename)
+				emit(Event{Event: "completed", File: t.Filename, URL: t.URL(), Cached: true})
 			} else {
 				succeeded++
-				printf("[%d/%d] Downloaded %s from %s\n", succeeded, len(downloads), resp.Filename, resp.Request.URL())
+				if t.Attempts() > 1 {
+					retried++
+				}
+				printf("[%d/%d] Downloaded %s from %s\n", succeeded, len(downloads), t.Filename, t.URL())
+				emit(Event{Event: "completed", File: t.Filename, URL: t.URL(), Attempt: t.Attempts(), Bytes: resp.BytesComplete(), Size: resp.Size()})
 			}
-			responses[i] = nil
+			transfers[i] = nil
+		}
+	}
+
+	if !eventsEnabled() || time.Since(lastProgress) < time.Second {
+		return
+	}
+	lastProgress = time.Now()
+	for _, t := range transfers {
+		if t == nil {
+			continue
+		}
+		if resp := t.Response(); resp != nil {
+			emit(Event{Event: "progress", File: t.Filename, Attempt: t.Attempts(), Bytes: resp.BytesComplete(), Size: resp.Size()})
 		}
 	}
 }
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mergeExts are the text config files merged line by line when both the pack
+// and the server owner changed them.
+var mergeExts = map[string]bool{
+	".cfg":        true,
+	".toml":       true,
+	".json":       true,
+	".json5":      true,
+	".properties": true,
+}
+
+// maxDiffEdits bounds the work of comparing two files. Files further apart
+// than this are treated as completely different.
+const maxDiffEdits = 4000
+
+func mergeable(path string) bool {
+	return mergeExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// hunk is a run of lines that differ between two files: o[OStart:OEnd] was
+// replaced by x[XStart:XEnd].
+type hunk struct {
+	OStart, OEnd int
+	XStart, XEnd int
+}
+
+// splitLines splits text after each newline, so joining the lines gives back
+// the text exactly, line endings and all.
+func splitLines(text []byte) []string {
+	var lines []string
+	for len(text) > 0 {
+		i := bytes.IndexByte(text, '\n')
+		if i < 0 {
+			lines = append(lines, string(text))
+			break
+		}
+		lines = append(lines, string(text[:i+1]))
+		text = text[i+1:]
+	}
+	return lines
+}
+
+// diffLines finds the hunks turning o into x, using Myers' algorithm.
+func diffLines(o []string, x []string) []hunk {
+	n, m := len(o), len(x)
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[d] holds v for diagonals -d..d before step d.
+	var trace [][]int
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				i = v[offset+k+1]
+			} else {
+				i = v[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && o[i] == x[j] {
+				i++
+				j++
+			}
+			v[offset+k] = i
+			if i >= n && j >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		if n == 0 && m == 0 {
+			return nil
+		}
+		return []hunk{{0, n, 0, m}}
+	}
+
+	// Walk back from the end to find the lines that match.
+	type match struct{ o, x int }
+	var matches []match
+	i, j := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d] }
+		k := i - j
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevI := at(prevK)
+		prevJ := prevI - prevK
+		for i > prevI && j > prevJ {
+			i--
+			j--
+			matches = append(matches, match{i, j})
+		}
+		i, j = prevI, prevJ
+	}
+	for i > 0 && j > 0 {
+		i--
+		j--
+		matches = append(matches, match{i, j})
+	}
+
+	var hunks []hunk
+	lastO, lastX := 0, 0
+	for idx := len(matches) - 1; idx >= -1; idx-- {
+		nextO, nextX := n, m
+		if idx >= 0 {
+			nextO, nextX = matches[idx].o, matches[idx].x
+		}
+		if nextO > lastO || nextX > lastX {
+			hunks = append(hunks, hunk{lastO, nextO, lastX, nextX})
+		}
+		lastO, lastX = nextO+1, nextX+1
+	}
+	return hunks
+}
+
+// mergeChunk is part of a three-way merge: either lines that merged cleanly,
+// or a conflict between the local and updated lines.
+type mergeChunk struct {
+	Lines    []string
+	Conflict bool
+	Local    []string
+	Base     []string
+	Update   []string
+	// Line is where the chunk starts in the merged file, counting from 1.
+	Line int
+}
+
+type sideHunk struct {
+	hunk
+	update bool
+}
+
+// merge3 merges the changes from base to local and from base to update. Where
+// both changed the same or neighbouring lines differently, the chunk is a
+// conflict.
+func merge3(base []string, local []string, update []string) []mergeChunk {
+	var hunks []sideHunk
+	localHunks := diffLines(base, local)
+	updateHunks := diffLines(base, update)
+	for li, ui := 0, 0; li < len(localHunks) || ui < len(updateHunks); {
+		if ui >= len(updateHunks) || (li < len(localHunks) && localHunks[li].OStart <= updateHunks[ui].OStart) {
+			hunks = append(hunks, sideHunk{localHunks[li], false})
+			li++
+		} else {
+			hunks = append(hunks, sideHunk{updateHunks[ui], true})
+			ui++
+		}
+	}
+
+	var chunks []mergeChunk
+	line := 1
+	add := func(chunk mergeChunk) {
+		chunk.Line = line
+		if chunk.Conflict {
+			line += len(chunk.Local)
+		} else {
+			if len(chunk.Lines) == 0 {
+				return
+			}
+			line += len(chunk.Lines)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	// Offsets of local and update lines from base lines after the last hunk.
+	localDelta, updateDelta := 0, 0
+	pos := 0
+	for i := 0; i < len(hunks); {
+		lo, hi := hunks[i].OStart, hunks[i].OEnd
+		j := i + 1
+		// Changes next to each other conflict, as they do in git.
+		for j < len(hunks) && hunks[j].OStart <= hi {
+			if hunks[j].OEnd > hi {
+				hi = hunks[j].OEnd
+			}
+			j++
+		}
+		group := hunks[i:j]
+		i = j
+
+		add(mergeChunk{Lines: base[pos:lo]})
+		pos = hi
+
+		localLo, localHi := lo+localDelta, hi+localDelta
+		updateLo, updateHi := lo+updateDelta, hi+updateDelta
+		changedLocal, changedUpdate := false, false
+		for side := 0; side < 2; side++ {
+			var first, last *sideHunk
+			for h := range group {
+				if group[h].update == (side == 1) {
+					if first == nil {
+						first = &group[h]
+					}
+					last = &group[h]
+				}
+			}
+			if first == nil {
+				continue
+			}
+			xLo := first.XStart - (first.OStart - lo)
+			xHi := last.XEnd + (hi - last.OEnd)
+			if side == 0 {
+				localLo, localHi, changedLocal = xLo, xHi, true
+			} else {
+				updateLo, updateHi, changedUpdate = xLo, xHi, true
+			}
+		}
+		localDelta, updateDelta = localHi-hi, updateHi-hi
+
+		localLines, updateLines := local[localLo:localHi], update[updateLo:updateHi]
+		switch {
+		case !changedUpdate:
+			add(mergeChunk{Lines: localLines})
+		case !changedLocal || equalLines(localLines, updateLines):
+			add(mergeChunk{Lines: updateLines})
+		default:
+			add(mergeChunk{Conflict: true, Local: localLines, Base: base[lo:hi], Update: updateLines})
+		}
+	}
+	add(mergeChunk{Lines: base[pos:]})
+	return chunks
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeResult is the outcome of merging a config file.
+type MergeResult struct {
+	// Text is the merged file. Conflicts are written with markers, or, if
+	// rejects were asked for, keep the local lines.
+	Text []byte
+	// Rejects lists the conflicts, for a .rej file next to the merged file.
+	Rejects   []byte
+	Conflicts int
+}
+
+// writeConflict writes chunk with conflict markers, diff3 style.
+func writeConflict(buf *bytes.Buffer, chunk mergeChunk) {
+	section := func(marker string, lines []string) {
+		buf.WriteString(marker + "\n")
+		for _, line := range lines {
+			buf.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				buf.WriteString("\n")
+			}
+		}
+	}
+	section("<<<<<<< yours", chunk.Local)
+	section("||||||| old pack version", chunk.Base)
+	section("=======", chunk.Update)
+	buf.WriteString(">>>>>>> new pack version\n")
+}
+
+// Merge3 merges the server owner's changes from base to local with the pack's
+// changes from base to update. With rejects, conflicting parts of the file
+// are left as they are locally and reported in Rejects instead of being marked
+// in the file. name is only used in Rejects.
+func Merge3(name string, base []byte, local []byte, update []byte, rejects bool) MergeResult {
+	var result MergeResult
+	var text, rej bytes.Buffer
+	for _, chunk := range merge3(splitLines(base), splitLines(local), splitLines(update)) {
+		if !chunk.Conflict {
+			text.WriteString(strings.Join(chunk.Lines, ""))
+			continue
+		}
+		result.Conflicts++
+		if !rejects {
+			writeConflict(&text, chunk)
+			continue
+		}
+		text.WriteString(strings.Join(chunk.Local, ""))
+		fmt.Fprintf(&rej, "# %s, line %d\n", name, chunk.Line)
+		writeConflict(&rej, chunk)
+	}
+	result.Text = text.Bytes()
+	result.Rejects = rej.Bytes()
+	// A clean merge of JSON can still be broken, such as by two new entries
+	// at the end of an object. Keep the local file rather than break it.
+	if result.Conflicts == 0 && strings.ToLower(filepath.Ext(name)) == ".json" && json.Valid(local) && !json.Valid(result.Text) {
+		result.Conflicts = 1
+		if rejects {
+			result.Text = local
+			result.Rejects = []byte(fmt.Sprintf("# %s could not be merged into valid JSON. The new pack version is:\n%s", name, update))
+		} else {
+			var buf bytes.Buffer
+			writeConflict(&buf, mergeChunk{Local: splitLines(local), Base: splitLines(base), Update: splitLines(update)})
+			result.Text = buf.Bytes()
+		}
+	}
+	return result
+}
+
+// isText reports whether data looks like text rather than binary.
+func isText(data []byte) bool {
+	return bytes.IndexByte(data, 0) < 0
+}
+
+// pristineDir keeps the pack's own copy of each mergeable file, relative to
+// the install path. It is the common base when both the pack and the server
+// owner changed a config.
+var pristineDir = filepath.Join(stateDir, "pristine")
+
+func pristinePath(root string, download Download) string {
+	return filepath.Join(root, pristineDir, filepath.Clean(download.FullPath))
+}
+
+// SavePristine copies the mergeable files of downloads from src into the
+// pristine copies kept in root.
+func SavePristine(root string, src string, downloads []Download) error {
+	for _, download := range downloads {
+		if !mergeable(download.FullPath) || !safeRelPath(filepath.Clean(download.FullPath)) {
+			continue
+		}
+		filename := download.Filename(src)
+		if _, err := os.Stat(filename); err != nil {
+			continue
+		}
+		LogIfVerbose("Keeping a pristine copy of %s\n", download.FullPath)
+		if err := placeFile(filename, pristinePath(root, download), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeCandidates splits the locally modified files an update changes into
+// those that can be merged, being text configs with a pristine copy of the
+// installed version, and the rest.
+func MergeCandidates(installPath string, changes []FileChange) (merges []FileChange, rest []FileChange) {
+	for _, change := range changes {
+		if !Options.Integrityupdate && mergeable(change.New.FullPath) && fileMatches(change.Old, pristinePath(installPath, change.Old)) {
+			merges = append(merges, change)
+		} else {
+			rest = append(rest, change)
+		}
+	}
+	return merges, rest
+}
+
+// mergeStaged merges the local changes to each of merges in installPath into
+// the new version staged in stage, leaving the result in stage. It returns
+// the paths of the files merged cleanly and of those with conflicts.
+func mergeStaged(installPath string, stage string, merges []FileChange) (error, []string, []string) {
+	var merged, conflicts []string
+	rejects := Options.Mergeconflicts == "rej"
+	for _, change := range merges {
+		path := filepath.Clean(change.New.FullPath)
+		base, err := os.ReadFile(pristinePath(installPath, change.Old))
+		if err != nil {
+			return err, nil, nil
+		}
+		localFile := filepath.Join(installPath, path)
+		local, err := os.ReadFile(localFile)
+		if err != nil {
+			return err, nil, nil
+		}
+		stagedFile := change.New.Filename(stage)
+		update, err := os.ReadFile(stagedFile)
+		if err != nil {
+			return err, nil, nil
+		}
+		mode := os.FileMode(0644)
+		if fi, err := os.Stat(localFile); err == nil {
+			mode = fi.Mode().Perm()
+		}
+
+		var result MergeResult
+		if isText(base) && isText(local) && isText(update) {
+			result = Merge3(path, base, local, update, rejects)
+		} else {
+			// Not really text, so keep the local file as it is.
+			result = MergeResult{Text: local, Conflicts: 1}
+		}
+		LogIfVerbose("Merged %s with %d conflicts\n", path, result.Conflicts)
+		if err := os.WriteFile(stagedFile, result.Text, mode); err != nil {
+			return err, nil, nil
+		}
+		if len(result.Rejects) > 0 {
+			if err := os.WriteFile(stagedFile+".rej", result.Rejects, 0644); err != nil {
+				return err, nil, nil
+			}
+		}
+		if result.Conflicts > 0 {
+			conflicts = append(conflicts, path)
+		} else {
+			merged = append(merged, path)
+		}
+	}
+	return nil, merged, conflicts
+}
+
+// printMerges lists the configs merged by an update, and the conflicts left
+// for the server owner to resolve.
+func printMerges() {
+	if len(runSummary.Merged) > 0 {
+		printfln("Merged local changes into %d config files:", len(runSummary.Merged))
+		for _, path := range runSummary.Merged {
+			printfln("  %s", path)
+		}
+	}
+	if len(runSummary.Conflicts) > 0 {
+		if Options.Mergeconflicts == "rej" {
+			printfln("%d config files kept local changes that conflict with the update, see the .rej files next to them:", len(runSummary.Conflicts))
+		} else {
+			printfln("%d config files have conflicts marked in them to resolve before starting the server:", len(runSummary.Conflicts))
+		}
+		for _, path := range runSummary.Conflicts {
+			printfln("  %s", path)
+		}
+	}
+}
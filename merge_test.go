@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// conflict is how Merge3 marks up a conflict.
+func conflict(local string, base string, update string) string {
+	return "<<<<<<< yours\n" + local + "||||||| old pack version\n" + base + "=======\n" + update + ">>>>>>> new pack version\n"
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		o, x string
+		want []hunk
+	}{
+		{"empty", "", "", nil},
+		{"same", "a\nb\n", "a\nb\n", nil},
+		{"insert", "a\nc\n", "a\nb\nc\n", []hunk{{1, 1, 1, 2}}},
+		{"delete", "a\nb\nc\n", "a\nc\n", []hunk{{1, 2, 1, 1}}},
+		{"change", "a\nb\nc\n", "a\nB\nc\n", []hunk{{1, 2, 1, 2}}},
+		{"two changes", "a\nb\nc\nd\n", "A\nb\nc\nD\n", []hunk{{0, 1, 0, 1}, {3, 4, 3, 4}}},
+		{"from nothing", "", "a\n", []hunk{{0, 0, 0, 1}}},
+		{"to nothing", "a\n", "", []hunk{{0, 1, 0, 0}}},
+		{"line ending", "a\r\nb\r\n", "a\r\nb\n", []hunk{{1, 2, 1, 2}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := diffLines(splitLines([]byte(test.o)), splitLines([]byte(test.x))); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("diffLines = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func numberedLines(prefix string, n int) string {
+	var buf strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "%s%d\n", prefix, i)
+	}
+	return buf.String()
+}
+
+func TestDiffLinesMaxEdits(t *testing.T) {
+	n := maxDiffEdits/2 + 100
+	base := splitLines([]byte(numberedLines("line ", n)))
+
+	// One change is found exactly, however long the files are.
+	changed := append([]string(nil), base...)
+	changed[n/2] = "changed\n"
+	if got, want := diffLines(base, changed), []hunk{{n / 2, n/2 + 1, n / 2, n/2 + 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLines = %v, want %v", got, want)
+	}
+
+	// Files more than maxDiffEdits apart are a single change.
+	other := splitLines([]byte(numberedLines("other ", n)))
+	if got, want := diffLines(base, other), []hunk{{0, n, 0, n}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLines = %v, want %v", got, want)
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name                string
+		file                string
+		base, local, update string
+		rejects             bool
+		want, wantRejects   string
+		wantConflicts       int
+	}{
+		{
+			name:   "local only",
+			base:   "a\nb\nc\n",
+			local:  "a\nB\nc\n",
+			update: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "update only",
+			base:   "a\nb\nc\n",
+			local:  "a\nb\nc\n",
+			update: "a\nb\nC\n",
+			want:   "a\nb\nC\n",
+		},
+		{
+			name:   "both apart",
+			base:   "a\nb\nc\nd\ne\n",
+			local:  "A\nb\nc\nd\ne\n",
+			update: "a\nb\nc\nd\nE\nf\n",
+			want:   "A\nb\nc\nd\nE\nf\n",
+		},
+		{
+			name:   "identical edits",
+			base:   "a\nb\nc\n",
+			local:  "a\nX\nc\nd\n",
+			update: "a\nX\nc\nd\n",
+			want:   "a\nX\nc\nd\n",
+		},
+		{
+			name:          "same line",
+			base:          "a\nb\nc\n",
+			local:         "a\nL\nc\n",
+			update:        "a\nU\nc\n",
+			want:          "a\n" + conflict("L\n", "b\n", "U\n") + "c\n",
+			wantConflicts: 1,
+		},
+		{
+			name:          "adjacent lines",
+			base:          "a\nb\nc\nd\n",
+			local:         "a\nB\nc\nd\n",
+			update:        "a\nb\nC\nd\n",
+			want:          "a\n" + conflict("B\nc\n", "b\nc\n", "b\nC\n") + "d\n",
+			wantConflicts: 1,
+		},
+		{
+			name:          "same insertion point",
+			base:          "a\nb\n",
+			local:         "a\nL\nb\n",
+			update:        "a\nU\nb\n",
+			want:          "a\n" + conflict("L\n", "", "U\n") + "b\n",
+			wantConflicts: 1,
+		},
+		{
+			name:          "rejects",
+			file:          "config/a.toml",
+			base:          "a\nb\nc\n",
+			local:         "a\nL\nc\n",
+			update:        "a\nU\nc\n",
+			rejects:       true,
+			want:          "a\nL\nc\n",
+			wantRejects:   "# config/a.toml, line 2\n" + conflict("L\n", "b\n", "U\n"),
+			wantConflicts: 1,
+		},
+		{
+			name:          "rejects line numbers",
+			file:          "config/a.toml",
+			base:          "a\nb\nc\nd\ne\n",
+			local:         "x\na\nB1\nc\nd\nE1\n",
+			update:        "a\nB2\nc\nd\nE2\n",
+			rejects:       true,
+			want:          "x\na\nB1\nc\nd\nE1\n",
+			wantRejects:   "# config/a.toml, line 3\n" + conflict("B1\n", "b\n", "B2\n") + "# config/a.toml, line 6\n" + conflict("E1\n", "e\n", "E2\n"),
+			wantConflicts: 2,
+		},
+		{
+			name:   "crlf",
+			base:   "a\r\nb\r\nc\r\n",
+			local:  "A\r\nb\r\nc\r\n",
+			update: "a\r\nb\r\nC\r\n",
+			want:   "A\r\nb\r\nC\r\n",
+		},
+		{
+			name:          "crlf conflict",
+			base:          "a\r\nb\r\nc\r\n",
+			local:         "a\r\nL\r\nc\r\n",
+			update:        "a\r\nU\r\nc\r\n",
+			want:          "a\r\n" + conflict("L\r\n", "b\r\n", "U\r\n") + "c\r\n",
+			wantConflicts: 1,
+		},
+		{
+			name:   "no final newline",
+			base:   "a\nb\nc",
+			local:  "A\nb\nc",
+			update: "a\nb\nC",
+			want:   "A\nb\nC",
+		},
+		{
+			name:   "final newline added",
+			base:   "a\nb\nc",
+			local:  "a\nb\nc\n",
+			update: "A\nb\nc",
+			want:   "A\nb\nc\n",
+		},
+		{
+			name:          "no final newline conflict",
+			base:          "a\nb",
+			local:         "a\nL",
+			update:        "a\nU",
+			want:          "a\n" + conflict("L\n", "b\n", "U\n"),
+			wantConflicts: 1,
+		},
+		{
+			name:   "json",
+			file:   "config/a.json",
+			base:   "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3\n}\n",
+			local:  "{\n\"a\": 10,\n\"b\": 2,\n\"c\": 3\n}\n",
+			update: "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 30\n}\n",
+			want:   "{\n\"a\": 10,\n\"b\": 2,\n\"c\": 30\n}\n",
+		},
+		{
+			name:          "invalid json",
+			file:          "config/a.json",
+			base:          "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3\n}\n",
+			local:         "{\n\"a\": 10,\n\"b\": 2,\n\"c\": 3\n}\n",
+			update:        "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3,\n}\n",
+			want:          conflict("{\n\"a\": 10,\n\"b\": 2,\n\"c\": 3\n}\n", "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3\n}\n", "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3,\n}\n"),
+			wantConflicts: 1,
+		},
+		{
+			name:          "invalid json rejects",
+			file:          "config/a.json",
+			base:          "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3\n}\n",
+			local:         "{\n\"a\": 10,\n\"b\": 2,\n\"c\": 3\n}\n",
+			update:        "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3,\n}\n",
+			rejects:       true,
+			want:          "{\n\"a\": 10,\n\"b\": 2,\n\"c\": 3\n}\n",
+			wantRejects:   "# config/a.json could not be merged into valid JSON. The new pack version is:\n{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3,\n}\n",
+			wantConflicts: 1,
+		},
+		{
+			name:   "invalid json5",
+			file:   "config/a.json5",
+			base:   "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3\n}\n",
+			local:  "{\n\"a\": 10,\n\"b\": 2,\n\"c\": 3\n}\n",
+			update: "{\n\"a\": 1,\n\"b\": 2,\n\"c\": 3,\n}\n",
+			want:   "{\n\"a\": 10,\n\"b\": 2,\n\"c\": 3,\n}\n",
+		},
+		{
+			name:          "too far apart",
+			base:          numberedLines("line ", maxDiffEdits/2+100),
+			local:         numberedLines("local ", maxDiffEdits/2+100),
+			update:        "changed\n" + numberedLines("line ", maxDiffEdits/2+100)[len("line 0\n"):],
+			want:          conflict(numberedLines("local ", maxDiffEdits/2+100), numberedLines("line ", maxDiffEdits/2+100), "changed\n"+numberedLines("line ", maxDiffEdits/2+100)[len("line 0\n"):]),
+			wantConflicts: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := test.file
+			if len(file) == 0 {
+				file = "config/a.cfg"
+			}
+			result := Merge3(file, []byte(test.base), []byte(test.local), []byte(test.update), test.rejects)
+			if !bytes.Equal(result.Text, []byte(test.want)) {
+				t.Errorf("Text = %q, want %q", result.Text, test.want)
+			}
+			if !bytes.Equal(result.Rejects, []byte(test.wantRejects)) {
+				t.Errorf("Rejects = %q, want %q", result.Rejects, test.wantRejects)
+			}
+			if result.Conflicts != test.wantConflicts {
+				t.Errorf("Conflicts = %d, want %d", result.Conflicts, test.wantConflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mirrorFailPenalty = 3
+	mirrorSlowPenalty = 1
+	// A download of at least mirrorSlowSize below mirrorSlowRate bytes per
+	// second marks its host as slow.
+	mirrorSlowSize = 1 << 20
+	mirrorSlowRate = 100 << 10
+)
+
+// mirrorScores rank hosts for the rest of the run. Every host starts at zero,
+// loses points for failures and slow downloads, and earns them back by
+// succeeding.
+var mirrorScores = struct {
+	sync.Mutex
+	scores map[string]int
+}{scores: make(map[string]int)}
+
+func scoreMirror(host string, change int) {
+	mirrorScores.Lock()
+	defer mirrorScores.Unlock()
+	score := mirrorScores.scores[host] + change
+	if score > 0 {
+		score = 0
+	}
+	mirrorScores.scores[host] = score
+}
+
+// mirrorFailed deprioritises host after a failed download from it.
+func mirrorFailed(host string) {
+	scoreMirror(host, -mirrorFailPenalty)
+}
+
+// mirrorSucceeded records a download of size bytes from host that took
+// duration.
+func mirrorSucceeded(host string, size int64, duration time.Duration) {
+	if size >= mirrorSlowSize && float64(size)/duration.Seconds() < mirrorSlowRate {
+		LogIfVerbose("%s is slow, preferring other mirrors\n", host)
+		scoreMirror(host, -mirrorSlowPenalty)
+		return
+	}
+	scoreMirror(host, 1)
+}
+
+// Candidates is the URL and mirrors of the download, best scoring host first.
+// Hosts with the same score keep their order.
+func (d Download) Candidates() []url.URL {
+	candidates := append([]url.URL{d.URL}, d.Mirrors...)
+	mirrorScores.Lock()
+	defer mirrorScores.Unlock()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return mirrorScores.scores[candidates[i].Host] > mirrorScores.scores[candidates[j].Host]
+	})
+	return candidates
+}
+
+// Maven repositories the mod loaders and their libraries come from.
+const (
+	creeperMaven  = "https://maven.creeperhost.net/"
+	forgeMaven    = "https://maven.minecraftforge.net/"
+	neoForgeMaven = "https://maven.neoforged.net/releases/"
+	fabricMaven   = "https://maven.fabricmc.net/"
+	mojangMaven   = "https://libraries.minecraft.net/"
+)
+
+// builtinMirrors are tried for each kind of artifact after configured ones.
+var builtinMirrors = map[string][]string{
+	"":         {creeperMaven},
+	"forge":    {creeperMaven, forgeMaven},
+	"neoforge": {neoForgeMaven},
+	"fabric":   {fabricMaven},
+	"mojang":   {mojangMaven},
+}
+
+// mavenRoots are stripped from URLs to find the path of an artifact, so it can
+// be fetched from any mirror, and tell what kind of artifact it is.
+var mavenRoots = []struct {
+	root string
+	kind string
+}{
+	{"https://files.minecraftforge.net/maven/", "forge"},
+	{"https://apps.modpacks.ch/versions/", "forge"},
+	{forgeMaven, "forge"},
+	{creeperMaven, ""},
+	{neoForgeMaven, "neoforge"},
+	{fabricMaven, "fabric"},
+	{mojangMaven, "mojang"},
+}
+
+// mavenDir serves file:// URLs from the local file system.
+type mavenDir struct{}
+
+func (mavenDir) Open(name string) (http.File, error) {
+	if runtime.GOOS == "windows" {
+		// file:///C:/maven/... has the path /C:/maven/...
+		name = strings.TrimPrefix(name, "/")
+	}
+	return os.Open(filepath.FromSlash(name))
+}
+
+// normalizeMirror turns a mirror from the options into a base URL ending in a
+// slash. A local folder becomes a file:// URL.
+func normalizeMirror(mirror string) string {
+	mirror = strings.TrimSpace(mirror)
+	if !strings.Contains(mirror, "://") {
+		if abs, err := filepath.Abs(mirror); err == nil {
+			mirror = filepath.ToSlash(abs)
+			if !strings.HasPrefix(mirror, "/") {
+				mirror = "/" + mirror
+			}
+			mirror = "file://" + mirror
+		}
+	}
+	if !strings.HasSuffix(mirror, "/") {
+		mirror += "/"
+	}
+	return mirror
+}
+
+// configuredMirrors are the mirrors from the options for kind, followed by
+// the ones for every kind.
+func configuredMirrors(kind string) []string {
+	var mirrors []string
+	switch kind {
+	case "forge":
+		mirrors = append(mirrors, Options.Forgemirrors...)
+	case "neoforge":
+		mirrors = append(mirrors, Options.Neoforgemirrors...)
+	case "fabric":
+		mirrors = append(mirrors, Options.Fabricmirrors...)
+	case "mojang":
+		mirrors = append(mirrors, Options.Mojangmirrors...)
+	}
+	return append(mirrors, Options.Mirrors...)
+}
+
+// onlyConfigured is true if nothing but configured mirrors should be used for
+// kind.
+func onlyConfigured(kind string) bool {
+	return Options.Mirrorsonly && len(configuredMirrors(kind)) > 0
+}
+
+// GetMirrors lists the Maven repositories to fetch artifacts of kind from:
+// configured mirrors first, then the built in ones unless --mirrors-only is
+// set. The list is never empty.
+func GetMirrors(kind string) []string {
+	mirrors := configuredMirrors(kind)
+	if !onlyConfigured(kind) {
+		mirrors = append(mirrors, builtinMirrors[kind]...)
+	}
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, mirror := range mirrors {
+		mirror = normalizeMirror(mirror)
+		if !seen[mirror] {
+			seen[mirror] = true
+			normalized = append(normalized, mirror)
+		}
+	}
+	return normalized
+}
+
+// mavenURL is the URL of artifact, a path in a Maven repository, on the first
+// mirror for kind.
+func mavenURL(kind string, artifact string) string {
+	return GetMirrors(kind)[0] + artifact
+}
+
+// MirrorsFor lists the URLs to try for urlStr, a Maven URL or the path of an
+// artifact: every mirror from GetMirrors, then fallback, then urlStr itself.
+// If kind is empty it is worked out from urlStr.
+func MirrorsFor(urlStr string, fallback string, kind string) []url.URL {
+	artifact := urlStr
+	for _, maven := range mavenRoots {
+		if strings.HasPrefix(artifact, maven.root) {
+			artifact = strings.TrimPrefix(artifact, maven.root)
+			if len(kind) == 0 {
+				kind = maven.kind
+			}
+			break
+		}
+	}
+	if parsedURL, err := url.Parse(artifact); err == nil && parsedURL.IsAbs() {
+		// Some other Maven, assume the whole path is the artifact.
+		artifact = strings.TrimPrefix(parsedURL.Path, "/")
+	}
+	candidates := []string{}
+	for _, mirror := range GetMirrors(kind) {
+		candidates = append(candidates, mirror+artifact)
+	}
+	if !onlyConfigured(kind) {
+		candidates = append(candidates, fallback+artifact, urlStr)
+	}
+
+	seen := make(map[string]bool)
+	var urls []url.URL
+	for _, candidate := range candidates {
+		parsed, err := url.Parse(candidate)
+		if err != nil || !parsed.IsAbs() || seen[parsed.String()] {
+			continue
+		}
+		seen[parsed.String()] = true
+		urls = append(urls, *parsed)
+	}
+	return urls
+}
+
+// WithMirrors sets the URL and Mirrors of the download to the candidates from
+// MirrorsFor its URL.
+func (d Download) WithMirrors(fallback string, kind string) Download {
+	urls := MirrorsFor(d.URL.String(), fallback, kind)
+	if len(urls) > 0 {
+		d.URL = urls[0]
+		d.Mirrors = urls[1:]
+	}
+	return d
+}
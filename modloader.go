@@ -1,8 +1,10 @@
 package main
 
+import "context"
+
 type ModLoader interface {
-	GetDownloads(installPath string) []Download
-	Install(installPath string, java JavaProvider) bool
+	GetDownloads(ctx context.Context, installPath string) []Download
+	Install(ctx context.Context, installPath string, java JavaProvider) bool
 
 	// GetLaunchJar
 	// First return parameter describes the 'Main Jar'.
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"encoding/hex"
 	"encoding/json"
@@ -91,9 +92,13 @@ type Download struct {
 	HashType string
 	Hash     string
 	FullPath string
+	// Mirrors are tried in order if URL fails.
+	Mirrors []url.URL
+	// Size is the expected size in bytes, or 0 if unknown.
+	Size int64
 }
 
-func GetModpack(id int) (error, Modpack) {
+func GetModpack(ctx context.Context, id int) (error, Modpack) {
 	ret := Modpack{}
 	var newUrl string
 	if Options.Curseforge {
@@ -101,50 +106,70 @@ func GetModpack(id int) (error, Modpack) {
 	} else {
 		newUrl = fmt.Sprintf(BaseModpackURL+"%d", apiKey, id)
 	}
-	err := APICall(newUrl, &ret)
+	err := APICall(ctx, newUrl, &ret)
 	if err != nil {
 		return err, ret
 	}
 	return ret.GetError(), ret
 }
 
-func (m Modpack) GetVersion(versionId int) (error, VersionInfo) {
+// channelRank orders release channels from most to least stable. A channel
+// includes every version at least as stable as itself.
+var channelRank = map[string]int{"release": 0, "beta": 1, "alpha": 2}
+
+// InChannel reports whether the version belongs in the given release channel.
+// Versions without a type are treated as releases and unknown types as alpha.
+func (v Version) InChannel(channel string) bool {
+	rank, ok := channelRank[strings.ToLower(v.Type)]
+	if !ok {
+		if len(v.Type) == 0 {
+			rank = channelRank["release"]
+		} else {
+			rank = channelRank["alpha"]
+		}
+	}
+	return rank <= channelRank[channel]
+}
+
+// GetLatestVersion returns the most recently updated version in the channel,
+// or nil if the channel has no versions.
+func (m Modpack) GetLatestVersion(channel string) *Version {
+	var latest *Version
+	for i := range m.Versions {
+		v := &m.Versions[i]
+		if !v.InChannel(channel) {
+			continue
+		}
+		if latest == nil || v.Updated > latest.Updated || (v.Updated == latest.Updated && v.ID > latest.ID) {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// GetVersion fetches the version info for versionId, or for the latest version
+// in Options.Channel if versionId is -2.
+func (m Modpack) GetVersion(ctx context.Context, versionId int) (error, VersionInfo) {
 	var version *Version
 	var ret VersionInfo
-	latest := false
 	if versionId == -2 {
-		latest = true
-	}
-	highestId := -1
-	var highestVer *Version
-	for _, v := range m.Versions {
-		if latest {
-			if v.ID > highestId {
-				highestId = v.ID
-				highestVer = &v
-			}
-		} else {
-			if v.ID == versionId {
-				version = &v
+		version = m.GetLatestVersion(Options.Channel)
+		if version == nil {
+			return fmt.Errorf("no %s versions available, try a less stable --channel", Options.Channel), ret
+		}
+	} else {
+		for i := range m.Versions {
+			if m.Versions[i].ID == versionId {
+				version = &m.Versions[i]
 				break
 			}
 		}
 	}
-	if latest {
-		version = highestVer
-	}
 	if version == nil {
 		return errors.New("version does not exist"), ret
 	}
 
-	var newUrl string
-	if Options.Curseforge {
-		newUrl = fmt.Sprintf(BaseCurseforgeURL+"%d/%d", m.ID, version.ID)
-	} else {
-		newUrl = fmt.Sprintf(BaseModpackURL+"%d/%d", apiKey, m.ID, version.ID)
-	}
-
-	err := APICall(newUrl, &ret)
+	err := APICall(ctx, versionURL(m.ID, version.ID), &ret)
 	if err != nil {
 		return err, ret
 	}
@@ -152,6 +177,14 @@ func (m Modpack) GetVersion(versionId int) (error, VersionInfo) {
 	return ret.GetError(), ret
 }
 
+// versionURL is the API URL of the version info for versionId of modpackId.
+func versionURL(modpackId int, versionId int) string {
+	if Options.Curseforge {
+		return fmt.Sprintf(BaseCurseforgeURL+"%d/%d", modpackId, versionId)
+	}
+	return fmt.Sprintf(BaseModpackURL+"%d/%d", apiKey, modpackId, versionId)
+}
+
 func (v VersionInfo) GetDownloads() []Download {
 	var downloads []Download
 	for _, f := range v.Files {
@@ -163,7 +196,7 @@ func (v VersionInfo) GetDownloads() []Download {
 			//shrug
 			continue
 		}
-		downloads = append(downloads, Download{f.Path, *parse, f.Name, "sha1", f.SHA1, filepath.Join(f.Path, f.Name)})
+		downloads = append(downloads, Download{f.Path, *parse, f.Name, "sha1", f.SHA1, filepath.Join(f.Path, f.Name), nil, int64(f.Size)})
 	}
 	return downloads
 }
@@ -177,14 +210,8 @@ func (v VersionInfo) GetTargetVersion(targetType string) *string {
 	return nil
 }
 
-func (v VersionInfo) WriteJson(installPath string) bool {
-	var newUrl string
-	if Options.Curseforge {
-		newUrl = fmt.Sprintf(BaseCurseforgeURL+"%d/%d", v.ParentId, v.ID)
-	} else {
-		newUrl = fmt.Sprintf(BaseModpackURL+"%d/%d", apiKey, v.ParentId, v.ID)
-	}
-	req, err := http.NewRequest("GET", newUrl, nil)
+func (v VersionInfo) WriteJson(ctx context.Context, installPath string) bool {
+	req, err := http.NewRequestWithContext(ctx, "GET", versionURL(v.ParentId, v.ID), nil)
 	if err != nil {
 		return false
 	}
@@ -208,6 +235,9 @@ func (v VersionInfo) WriteStartScript(installPath string, loader ModLoader, java
 		jarStr = "-jar " + mainJar
 	}
 	jarStr += strings.Join(jvmArgs, " ")
+	if len(Options.Jvmargs) > 0 {
+		jarStr = Options.Jvmargs + " " + jarStr
+	}
 
 	// Hacky fix for curseforge packs
 	if v.Specs.Minimum == 0 {
@@ -216,6 +246,12 @@ func (v VersionInfo) WriteStartScript(installPath string, loader ModLoader, java
 	if v.Specs.Recommend == 0 {
 		v.Specs.Recommend = 4096
 	}
+	if Options.Xmx > 0 {
+		v.Specs.Recommend = Options.Xmx
+	}
+	if Options.Xms > 0 {
+		v.Specs.Minimum = Options.Xms
+	}
 	launch := fmt.Sprintf("-XX:+UseG1GC -XX:+UnlockExperimentalVMOptions -Xmx%dM -Xms%dM %s nogui", v.Specs.Recommend, v.Specs.Minimum, jarStr)
 	var script string
 	filename := "start"
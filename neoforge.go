@@ -1,11 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
@@ -14,11 +13,10 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/cavaliergopher/grab/v3"
 	hashVer "github.com/hashicorp/go-version"
 )
 
-func GetNeoForge(modloader Target, mc Minecraft) (error, ModLoader) {
+func GetNeoForge(ctx context.Context, modloader Target, mc Minecraft) (error, ModLoader) {
 	version := NeoForgeVersion{}
 	version.RawVersion = modloader.Version
 	version.Minecraft = mc
@@ -92,18 +90,15 @@ func (f *NeoForgeVersion) Parse() error {
 	return errors.New(fmt.Sprintf("NeoForge version does not match expected format: %s", f.RawVersion))
 }
 
-const neoForgeUrlInstallJar = "https://maven.neoforged.net/releases/net/neoforged/%s/%s/%s"
-const neoForgeUrlInstallJSON = "https://maven.neoforged.net/releases/net/neoforged/%s/%s/%s-%s.json"
-
-func GetNeoMirrors() []string {
-	return []string{"https://maven.neoforged.net/releases", "https://libraries.minecraft.net/"}
-}
+// Paths of NeoForge artifacts in a Maven repository.
+const neoForgeUrlInstallJar = "net/neoforged/%s/%s/%s"
+const neoForgeUrlInstallJSON = "net/neoforged/%s/%s/%s-%s.json"
 
 type NeoForgeInstall struct {
 	Version NeoForgeVersion
 }
 
-func (f NeoForgeInstall) GetDownloads(installPath string) []Download {
+func (f NeoForgeInstall) GetDownloads(ctx context.Context, installPath string) []Download {
 	printfln("Getting downloads for NeoForge Install")
 	var packageName string
 	var versionStr string
@@ -115,43 +110,18 @@ func (f NeoForgeInstall) GetDownloads(installPath string) []Download {
 		packageName = "forge"
 	}
 	installerName := fmt.Sprintf("%s-%s-installer.jar", packageName, versionStr)
-	forgeUrl := fmt.Sprintf(neoForgeUrlInstallJar, packageName, versionStr, installerName)
-	forgeUrlJSON := fmt.Sprintf(neoForgeUrlInstallJSON, packageName, versionStr, packageName, versionStr)
-	var rawForgeJSON []byte
-	var rawForgeInstallJSON []byte
-	if !FileOnServer(forgeUrlJSON) {
-		resp, err := grab.Get(installPath, forgeUrl)
-		if err != nil {
-			fatalf("JSON not on server and unable to get forge jar:\n%s\n%s\n %v", forgeUrlJSON, forgeUrl, err)
-		}
-		if resp.IsComplete() {
-			resp.Wait()
-		}
-		bytes, err := UnzipFileToMemory(filepath.Join(installPath, installerName), "version.json")
-		if err == nil {
-			rawForgeJSON = bytes
-		}
-	} else {
-		resp, err := http.Get(forgeUrlJSON)
-		if err == nil {
-			defer resp.Body.Close()
-			bytes, err := io.ReadAll(resp.Body)
-			if err == nil {
-				rawForgeJSON = bytes
-			}
-		}
-	}
-
-	bytes, err := UnzipFileToMemory(filepath.Join(installPath, installerName), "install_profile.json")
-	if err == nil {
-		rawForgeInstallJSON = bytes
+	forgeUrl := mavenURL("neoforge", fmt.Sprintf(neoForgeUrlInstallJar, packageName, versionStr, installerName))
+	forgeUrlJSON := mavenURL("neoforge", fmt.Sprintf(neoForgeUrlInstallJSON, packageName, versionStr, packageName, versionStr))
+	err, rawForgeJSON, rawForgeInstallJSON := readInstallerJsons(ctx, forgeUrl, forgeUrlJSON)
+	if err != nil {
+		fatalf("JSON not on server and unable to get forge jar:\n%s\n%s\n %v", forgeUrlJSON, forgeUrl, err)
 	}
 
 	URL, err := url.Parse(forgeUrl)
 	if err != nil {
 		fatalf("Unable to get forge jar as error parsing URL somehow: URL: %s, Error: %v", forgeUrl, err)
 	}
-	downloads := []Download{{"", *URL, installerName, "", "", filepath.Join("", installerName+".jar")}}
+	downloads := []Download{Download{"", *URL, installerName, "", "", filepath.Join("", installerName+".jar"), nil, 0}.WithMirrors("", "neoforge")}
 
 	if len(rawForgeJSON) > 0 {
 		versionForge := VersionJsonFG3{}
@@ -167,9 +137,9 @@ func (f NeoForgeInstall) GetDownloads(installPath string) []Download {
 			downloads = append(downloads, versionForge.GetDownloads()...)
 		}
 	}
-	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion()
+	vanillaVer, err := f.Version.Minecraft.GetVanillaVersion(ctx)
 	if err == nil {
-		serverDownload, err := vanillaVer.GetServerDownload()
+		serverDownload, err := vanillaVer.GetServerDownload(ctx)
 		if err == nil {
 			downloads = append(downloads, serverDownload)
 		}
@@ -180,7 +150,7 @@ func (f NeoForgeInstall) GetDownloads(installPath string) []Download {
 	return downloads
 }
 
-func (f NeoForgeInstall) Install(installPath string, java JavaProvider) bool {
+func (f NeoForgeInstall) Install(ctx context.Context, installPath string, java JavaProvider) bool {
 	printfln("Running NeoForge installer")
 	var packageName string
 	if f.Version.AfterBreaking {
@@ -209,17 +179,18 @@ Forge:
 	} else {
 		javaPath = java.GetJavaPath("")
 	}
-	fmt.Println("Java Path has been set to:", javaPath)
+	fmt.Fprintln(loggerOut, "Java Path has been set to:", javaPath)
 	LogIfVerbose("Running %s -Xmx%s -jar %s --installServer", javaPath, xmx, installerName)
-	cmd := exec.Command(javaPath, "-Xmx"+xmx, "-jar", installerName, "--installServer")
+	cmd := exec.CommandContext(ctx, javaPath, "-Xmx"+xmx, "-jar", installerName, "--installServer")
 	cmd.Dir = installPath
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = loggerOut
 	cmd.Stderr = os.Stderr
 	if err := cmd.Start(); err != nil {
 		fatalf(fmt.Sprintf("Running NeoForge installer failed with %s. You may wish to install NeoForge %s for Minecraft %s manually", err, f.Version.RawVersion, f.Version.Minecraft.RawVersion))
 		return false
 	}
 	if err := cmd.Wait(); err != nil {
+		stopIfInterrupted(ctx)
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			if exitErr.ExitCode() != 0 {
 				printfln(fmt.Sprintf("NeoForge installer failed with exit code %d, retrying...", exitErr.ExitCode()))
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// InstallPlan holds everything an install or update will do, worked out
+// before anything touches disk.
+type InstallPlan struct {
+	InstallPath string
+	Modpack     Modpack
+	VersionInfo VersionInfo
+	Upgrade     bool
+
+	// Previous is the version read from the existing version.json when
+	// upgrading. PreviousErr is set if it could not be read.
+	Previous    VersionInfo
+	PreviousErr error
+
+	// Files are the pack files to fetch and delete. A fresh install only has
+	// Added files.
+	Files UpdateDiff
+	// Protected are the pack files matching the protectFile of the install,
+	// which are left as they are.
+	Protect   ProtectedPaths
+	Protected []Download
+	// Merges are the locally modified configs the update merges rather than
+	// asking about.
+	Merges []FileChange
+	// WorkPath is where files are downloaded and the mod loader installed:
+	// the install path, or its staging folder for an update.
+	WorkPath string
+
+	ExtraDownloads     []Download
+	ModLoader          ModLoader
+	ModLoaderDownloads []Download
+	Java               JavaProvider
+	JavaDownloads      []Download
+}
+
+func BuildPlan(ctx context.Context, modpackId int, versionId int, installPath string) (error, *InstallPlan) {
+	plan := &InstallPlan{InstallPath: installPath, WorkPath: installPath}
+	emitPhase("resolve")
+
+	if _, err := os.Stat(filepath.Join(installPath, "version.json")); !os.IsNotExist(err) {
+		plan.Upgrade = true
+		plan.WorkPath = StagePath(installPath)
+	}
+
+	err, protect := ReadProtected(installPath)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", protectFile, err), nil
+	}
+	plan.Protect = protect
+
+	err, modpack := GetModpack(ctx, modpackId)
+	if err != nil {
+		return fmt.Errorf("error fetching modpack: %v", err), nil
+	}
+	plan.Modpack = modpack
+
+	err, versionInfo := modpack.GetVersion(ctx, versionId)
+	if err != nil {
+		return fmt.Errorf("error fetching modpack: %v", err), nil
+	}
+	plan.VersionInfo = versionInfo
+
+	if plan.Upgrade {
+		emitPhase("diff")
+		plan.PreviousErr, plan.Previous = GetVersionInfoFromFile(filepath.Join(installPath, "version.json"))
+		oldFiles, oldProtected := protect.Filter(plan.Previous.GetDownloads())
+		packFiles, protected := protect.Filter(versionInfo.GetDownloads())
+		plan.Files = DiffFiles(oldFiles, packFiles, installDir(installPath), Options.Integrity)
+		plan.Protected = uniqueByPath(append(oldProtected, protected...))
+	} else {
+		packFiles, protected := protect.Filter(versionInfo.GetDownloads())
+		plan.Files.Added = packFiles
+		plan.Protected = uniqueByPath(protected)
+	}
+
+	err, ml := versionInfo.GetModLoader(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting Modloader: %v", err), nil
+	}
+	plan.ModLoader = ml
+	plan.ModLoaderDownloads = ml.GetDownloads(ctx, plan.WorkPath)
+
+	plan.ExtraDownloads = []Download{log4jFixDownload()}
+
+	if Options.Nojava {
+		plan.Java = &NoOpJavaProvider{}
+	} else {
+		plan.Java = versionInfo.GetJavaProvider()
+	}
+	plan.JavaDownloads = plan.Java.GetDownloads(ctx, plan.WorkPath)
+
+	return nil, plan
+}
+
+func log4jFixDownload() Download {
+	URL, _ := url.Parse("https://media.forgecdn.net/files/3557/251/Log4jPatcher-1.0.0.jar")
+	return Download{"log4jfix/", *URL, "Log4jPatcher-1.0.0.jar", "sha1", "eb20584e179dc17b84b6b23fbda45485cd4ad7cc", filepath.Join("log4jfix/", "Log4jPatcher-1.0.0.jar"), nil, 0}
+}
+
+type planFile struct {
+	Path     string `json:"path"`
+	URL      string `json:"url,omitempty"`
+	HashType string `json:"hashType,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+type planVersion struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+type planOutput struct {
+	InstallPath string       `json:"installPath"`
+	Modpack     planVersion  `json:"modpack"`
+	Version     planVersion  `json:"version"`
+	Upgrade     bool         `json:"upgrade"`
+	Previous    *planVersion `json:"previous,omitempty"`
+	PreviousErr string       `json:"previousError,omitempty"`
+	Targets     []Target     `json:"targets"`
+	Files       struct {
+		Changed           []planFile `json:"changed"`
+		New               []planFile `json:"new"`
+		Deleted           []planFile `json:"deleted"`
+		Missing           []planFile `json:"missing"`
+		IntegrityFailures []planFile `json:"integrityFailures"`
+		LocallyModified   []planFile `json:"locallyModified"`
+		Merge             []planFile `json:"merge"`
+		Protected         []planFile `json:"protected"`
+	} `json:"files"`
+	Extra     []planFile `json:"extra"`
+	ModLoader []planFile `json:"modloader"`
+	Java      []planFile `json:"java"`
+	// RequiredSpace is roughly how many bytes the downloads need on disk.
+	RequiredSpace int64 `json:"requiredSpace"`
+}
+
+func toPlanFiles(downloads []Download) []planFile {
+	ret := make([]planFile, 0, len(downloads))
+	for _, d := range downloads {
+		ret = append(ret, planFile{d.FullPath, d.URL.String(), d.HashType, d.Hash, d.Size})
+	}
+	return ret
+}
+
+func newFiles(changes []FileChange) []Download {
+	ret := make([]Download, 0, len(changes))
+	for _, change := range changes {
+		ret = append(ret, change.New)
+	}
+	return ret
+}
+
+func (p *InstallPlan) output() planOutput {
+	var out planOutput
+	out.InstallPath = p.InstallPath
+	out.Modpack = planVersion{ID: p.Modpack.ID, Name: p.Modpack.Name}
+	if p.VersionInfo.Version != nil {
+		out.Version = planVersion{p.VersionInfo.ID, p.VersionInfo.Name, p.VersionInfo.Type}
+	}
+	out.Upgrade = p.Upgrade
+	if p.PreviousErr != nil {
+		out.PreviousErr = p.PreviousErr.Error()
+	} else if p.Previous.Version != nil {
+		out.Previous = &planVersion{p.Previous.ID, p.Previous.Name, p.Previous.Type}
+	}
+	out.Targets = p.VersionInfo.Targets
+	out.Files.Changed = toPlanFiles(newFiles(p.Files.Changed))
+	out.Files.New = toPlanFiles(p.Files.Added)
+	out.Files.Deleted = toPlanFiles(p.Files.Deleted)
+	out.Files.Missing = toPlanFiles(p.Files.Missing)
+	out.Files.IntegrityFailures = toPlanFiles(newFiles(p.Files.ModifiedUnchanged()))
+	merges, modified := MergeCandidates(p.InstallPath, p.Files.ModifiedUpdates())
+	out.Files.LocallyModified = toPlanFiles(newFiles(modified))
+	out.Files.Merge = toPlanFiles(newFiles(merges))
+	out.Files.Protected = toPlanFiles(p.Protected)
+	out.Extra = toPlanFiles(p.ExtraDownloads)
+	out.ModLoader = toPlanFiles(p.ModLoaderDownloads)
+	out.Java = toPlanFiles(p.JavaDownloads)
+	downloads := p.Files.Downloads(true)
+	downloads = append(downloads, p.ExtraDownloads...)
+	downloads = append(downloads, p.ModLoaderDownloads...)
+	downloads = append(downloads, p.JavaDownloads...)
+	out.RequiredSpace = RequiredSpace(downloads, p.JavaDownloads)
+	return out
+}
+
+// Print writes the plan as text or, if Options.Output is "json", as a single
+// JSON document.
+func (p *InstallPlan) Print(w io.Writer) error {
+	out := p.output()
+	if Options.Output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	action := "install"
+	if out.Upgrade {
+		action = "update"
+	}
+	fmt.Fprintf(w, "Plan to %s %s (%d) version %s (%d) in %s\n", action, out.Modpack.Name, out.Modpack.ID, out.Version.Name, out.Version.ID, out.InstallPath)
+	if out.Previous != nil {
+		fmt.Fprintf(w, "Currently installed: %s (%d)\n", out.Previous.Name, out.Previous.ID)
+	}
+	if len(out.PreviousErr) > 0 {
+		fmt.Fprintf(w, "Unable to read previous installation: %s\n", out.PreviousErr)
+	}
+	for _, target := range out.Targets {
+		fmt.Fprintf(w, "Target %s: %s %s\n", target.Type, target.Name, target.Version)
+	}
+
+	section := func(title string, files []planFile, withUrl bool) {
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(files))
+		for _, f := range files {
+			if withUrl && len(f.URL) > 0 {
+				fmt.Fprintf(w, "  %s <- %s\n", f.Path, f.URL)
+			} else {
+				fmt.Fprintf(w, "  %s\n", f.Path)
+			}
+		}
+	}
+
+	if out.Upgrade {
+		section("Changed files", out.Files.Changed, true)
+	}
+	section("New files", out.Files.New, true)
+	if out.Upgrade {
+		section("Deleted files", out.Files.Deleted, false)
+		section("Missing files", out.Files.Missing, true)
+		section("Changed files with local modifications", out.Files.LocallyModified, false)
+		section("Changed configs to merge with local modifications", out.Files.Merge, false)
+		section("Unchanged files failing integrity check", out.Files.IntegrityFailures, false)
+	}
+	section("Protected files left as they are", out.Files.Protected, false)
+	section("Additional downloads", out.Extra, true)
+	section("Mod loader downloads", out.ModLoader, true)
+	section("Java downloads", out.Java, true)
+	fmt.Fprintf(w, "\nAbout %s of disk space is needed\n", formatSize(out.RequiredSpace))
+	return nil
+}
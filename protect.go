@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// protectFile lists gitignore style patterns of paths in the install that
+// updates, verify and repair leave alone, such as configs kept by hand.
+const protectFile = ".serverdownloaderignore"
+
+type protectRule struct {
+	re *regexp.Regexp
+	// negate un-protects paths matched by earlier rules.
+	negate bool
+	// dirOnly rules end in a slash, and only match folders.
+	dirOnly bool
+}
+
+// ProtectedPaths are the rules read from protectFile. The last rule matching
+// a path decides whether it is protected, and everything in a protected
+// folder is protected too.
+type ProtectedPaths []protectRule
+
+// ReadProtected reads the protectFile of installPath, if it has one.
+func ReadProtected(installPath string) (error, ProtectedPaths) {
+	f, err := os.Open(filepath.Join(installPath, protectFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return err, nil
+	}
+	defer f.Close()
+
+	var rules ProtectedPaths
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		err, rule, ok := parseProtectRule(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %v", protectFile, line, err), nil
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err, nil
+	}
+	return nil, rules
+}
+
+func parseProtectRule(line string) (error, protectRule, bool) {
+	var rule protectRule
+	pattern := strings.TrimRight(strings.TrimSuffix(line, "\r"), " \t")
+	if len(pattern) == 0 || strings.HasPrefix(pattern, "#") {
+		return nil, rule, false
+	}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if len(pattern) == 0 {
+		return nil, rule, false
+	}
+	// Patterns with a slash are relative to the install, others match at any
+	// depth.
+	prefix := "(?:.*/)?"
+	if strings.Contains(pattern, "/") {
+		prefix = ""
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	re, err := regexp.Compile("^" + prefix + globRegexp(pattern) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid pattern %s", line), rule, false
+	}
+	rule.re = re
+	return nil, rule, true
+}
+
+// globRegexp turns a gitignore glob into a regular expression. * and ? don't
+// match slashes, but ** matches any number of folders.
+func globRegexp(pattern string) string {
+	var re strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				re.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+func (r protectRule) matches(rel string, isDir bool) bool {
+	if (isDir || !r.dirOnly) && r.re.MatchString(rel) {
+		return true
+	}
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if r.re.MatchString(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether rel, a path relative to the install, is protected.
+// The installer's own files never are.
+func (p ProtectedPaths) Match(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if rel == stateDir || strings.HasPrefix(rel, stateDir+"/") {
+		return false
+	}
+	protected := false
+	for _, rule := range p {
+		if rule.matches(rel, isDir) {
+			protected = !rule.negate
+		}
+	}
+	return protected
+}
+
+// Filter splits downloads into those that are not protected and those that
+// are.
+func (p ProtectedPaths) Filter(downloads []Download) (kept []Download, protected []Download) {
+	if len(p) == 0 {
+		return downloads, nil
+	}
+	for _, download := range downloads {
+		if p.Match(download.FullPath, false) {
+			protected = append(protected, download)
+		} else {
+			kept = append(kept, download)
+		}
+	}
+	return kept, protected
+}
+
+// uniqueByPath lists downloads once per path, sorted by path, with the last
+// of any listed twice.
+func uniqueByPath(downloads []Download) []Download {
+	files, paths := byPath(downloads)
+	ret := make([]Download, 0, len(paths))
+	for _, path := range paths {
+		ret = append(ret, files[path])
+	}
+	return ret
+}
+
+func protectedList(downloads []Download) []string {
+	var paths []string
+	for _, download := range downloads {
+		paths = append(paths, filepath.Clean(download.FullPath))
+	}
+	return paths
+}
+
+// printProtected lists the protected files that were left as they are.
+func printProtected(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	printfln("Left %d protected files as they are:", len(paths))
+	for _, path := range paths {
+		printfln("  %s", path)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestProtectedPathsMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"exact file", []string{"config/mymod.toml"}, "config/mymod.toml", false, true},
+		{"exact file elsewhere", []string{"config/mymod.toml"}, "config/other.toml", false, false},
+		{"anchored", []string{"config/mymod.toml"}, "defaults/config/mymod.toml", false, false},
+		{"leading slash", []string{"/mymod.toml"}, "config/mymod.toml", false, false},
+		{"unanchored", []string{"mymod.toml"}, "config/mymod.toml", false, true},
+		{"folder glob", []string{"world*/"}, "world/level.dat", false, true},
+		{"folder glob nether", []string{"world*/"}, "world_nether/DIM-1/region/r.0.0.mca", false, true},
+		{"folder glob folder", []string{"world*/"}, "world_the_end", true, true},
+		{"folder glob file", []string{"world*/"}, "world", false, false},
+		{"jar glob", []string{"mods/custom-*.jar"}, "mods/custom-tweaks.jar", false, true},
+		{"jar glob other", []string{"mods/custom-*.jar"}, "mods/jei.jar", false, false},
+		{"jar glob subfolder", []string{"mods/custom-*.jar"}, "mods/extra/custom-tweaks.jar", false, false},
+		{"double star", []string{"config/**/*.json"}, "config/a/b/c.json", false, true},
+		{"double star no folder", []string{"config/**/*.json"}, "config/c.json", false, true},
+		{"double star suffix", []string{"kubejs/**"}, "kubejs/server_scripts/a.js", false, true},
+		{"question mark", []string{"log?.txt"}, "log1.txt", false, true},
+		{"question mark slash", []string{"a?b"}, "a/b", false, false},
+		{"class", []string{"mods/[ab]*.jar"}, "mods/b.jar", false, true},
+		{"negated class", []string{"mods/[!ab]*.jar"}, "mods/b.jar", false, false},
+		{"escaped", []string{`\!important.txt`}, "!important.txt", false, true},
+		{"comment", []string{"# config/mymod.toml"}, "config/mymod.toml", false, false},
+		{"crlf", []string{"config/mymod.toml\r"}, "config/mymod.toml", false, true},
+		{"negate after", []string{"*.toml", "!config/a.toml"}, "config/a.toml", false, false},
+		{"negate after other", []string{"*.toml", "!config/a.toml"}, "config/b.toml", false, true},
+		{"negate before", []string{"!config/a.toml", "*.toml"}, "config/a.toml", false, true},
+		{"negate in folder", []string{"config/", "!config/keep.toml"}, "config/keep.toml", false, false},
+		{"folder after negate", []string{"!config/keep.toml", "config/"}, "config/keep.toml", false, true},
+		{"state folder", []string{"*"}, ".serverdownloader/pristine/config/a.toml", false, false},
+		{"state folder itself", []string{".serverdownloader/"}, ".serverdownloader", true, false},
+		{"no rules", nil, "config/a.toml", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rules ProtectedPaths
+			for _, line := range test.rules {
+				err, rule, ok := parseProtectRule(line)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					rules = append(rules, rule)
+				}
+			}
+			if got := rules.Match(test.path, test.isDir); got != test.want {
+				t.Errorf("Match(%q) with %q = %v, want %v", test.path, test.rules, got, test.want)
+			}
+		})
+	}
+}
+
+func TestProtectedPathsInvalid(t *testing.T) {
+	if err, _, _ := parseProtectRule("config/[z-a].toml"); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestProtectedPathsFilter(t *testing.T) {
+	_, rule, _ := parseProtectRule("config/")
+	kept, protected := ProtectedPaths{rule}.Filter([]Download{file("config/a.toml", "1"), file("mods/a.jar", "2")})
+	if got := paths(kept); len(got) != 1 || got[0] != "mods/a.jar" {
+		t.Errorf("kept = %v", got)
+	}
+	if got := paths(protected); len(got) != 1 || got[0] != "config/a.toml" {
+		t.Errorf("protected = %v", got)
+	}
+}
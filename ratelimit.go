@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter shares a bandwidth limit between every download. It implements
+// grab.RateLimiter.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64 // bytes per second
+	// next is when the bytes handed out so far will have been sent at rate.
+	next time.Time
+}
+
+// limiter is nil unless --limit-rate is set.
+var limiter *rateLimiter
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSecond)}
+}
+
+// WaitN blocks until n more bytes can be transferred within the limit.
+func (l *rateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	// Allow a second's worth of burst after being idle, and no more.
+	if l.next.Before(now.Add(-time.Second)) {
+		l.next = now.Add(-time.Second)
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	wait := l.next.Sub(now)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bufferSize is how much a download reads between calls to WaitN, small
+// enough that a low limit still gives an even rate.
+func (l *rateLimiter) bufferSize() int {
+	size := int(l.rate / 8)
+	if size < 1024 {
+		size = 1024
+	}
+	if size > 32*1024 {
+		size = 32 * 1024
+	}
+	return size
+}
+
+var hostSlots = struct {
+	sync.Mutex
+	slots map[string]chan struct{}
+}{slots: make(map[string]chan struct{})}
+
+// acquireHost waits until fewer than --max-per-host downloads from host are
+// running, and returns the function that gives the slot back.
+func acquireHost(host string) func() {
+	if Options.Maxperhost < 1 {
+		return func() {}
+	}
+	hostSlots.Lock()
+	slot, ok := hostSlots.slots[host]
+	if !ok {
+		slot = make(chan struct{}, Options.Maxperhost)
+		hostSlots.slots[host] = slot
+	}
+	hostSlots.Unlock()
+
+	slot <- struct{}{}
+	return func() { <-slot }
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type RepairPlan struct {
+	PackFiles          []Download
+	ModLoaderFiles     []Download
+	ReinstallModLoader bool
+	JavaFiles          []Download
+	ReinstallJava      bool
+}
+
+func (r RepairPlan) Empty() bool {
+	return len(r.PackFiles) == 0 && len(r.ModLoaderFiles) == 0 && !r.ReinstallModLoader && !r.ReinstallJava
+}
+
+// launchFilesPresent checks that the jar or argument files the start script
+// needs from the mod loader exist.
+func launchFilesPresent(ml ModLoader, installPath string) bool {
+	mainJar, jvmArgs := ml.GetLaunchJar(installPath)
+	if mainJar == "insert-jar-here.jar" {
+		return false
+	}
+	files := []string{}
+	if len(mainJar) > 0 {
+		files = append(files, mainJar)
+	}
+	for _, arg := range jvmArgs {
+		if strings.HasPrefix(arg, "@") {
+			files = append(files, arg[1:])
+		}
+	}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(installPath, file)); err != nil {
+			LogIfVerbose("Mod loader file %s is missing\n", file)
+			return false
+		}
+	}
+	return true
+}
+
+// BuildRepairPlan works out which files of the install at installPath need
+// fetching again. The mod loader and Java are only reinstalled if the files
+// they put in place are missing. Files protect matches are left as they are.
+func BuildRepairPlan(ctx context.Context, installPath string, ml ModLoader, java JavaProvider, broken []Download, protect ProtectedPaths) RepairPlan {
+	plan := RepairPlan{PackFiles: broken}
+
+	log4jFix := log4jFixDownload()
+	if !protect.Match(log4jFix.FullPath, false) && !log4jFix.VerifyChecksum(installPath) {
+		plan.PackFiles = append(plan.PackFiles, log4jFix)
+	}
+
+	mlDownloads := ml.GetDownloads(ctx, installPath)
+	if !launchFilesPresent(ml, installPath) {
+		plan.ReinstallModLoader = true
+		plan.ModLoaderFiles = mlDownloads
+	} else {
+		for _, down := range mlDownloads {
+			// Only libraries stay in place after the loader is installed; the
+			// installer and server jars in the root are consumed by it.
+			if len(down.Hash) == 0 || filepath.IsAbs(down.Path) || filepath.Dir(filepath.Clean(down.FullPath)) == "." {
+				continue
+			}
+			if protect.Match(down.FullPath, false) {
+				continue
+			}
+			LogIfVerbose("Checking %s\n", down.FullPath)
+			if !down.VerifyChecksum(installPath) {
+				plan.ModLoaderFiles = append(plan.ModLoaderFiles, down)
+			}
+		}
+	}
+
+	javaDownloads := java.GetDownloads(ctx, installPath)
+	if len(javaDownloads) > 0 {
+		if _, err := os.Stat(java.GetJavaPath(installPath)); err != nil {
+			LogIfVerbose("Java is missing from %s\n", java.GetJavaPath(installPath))
+			plan.ReinstallJava = true
+			plan.JavaFiles = javaDownloads
+		}
+	}
+
+	return plan
+}
+
+type repairOutput struct {
+	PackFiles          []planFile `json:"files"`
+	ModLoaderFiles     []planFile `json:"modloader"`
+	ReinstallModLoader bool       `json:"reinstallModloader"`
+	ReinstallJava      bool       `json:"reinstallJava"`
+}
+
+func (r RepairPlan) Print() {
+	if Options.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(repairOutput{toPlanFiles(r.PackFiles), toPlanFiles(r.ModLoaderFiles), r.ReinstallModLoader, r.ReinstallJava})
+		return
+	}
+	if r.Empty() {
+		fmt.Println("Nothing to repair")
+		return
+	}
+	section := func(title string, files []Download) {
+		if len(files) == 0 {
+			return
+		}
+		fmt.Printf("%s (%d):\n", title, len(files))
+		for _, f := range files {
+			fmt.Printf("  %s\n", f.FullPath)
+		}
+	}
+	section("Pack files to download again", r.PackFiles)
+	section("Mod loader files to download again", r.ModLoaderFiles)
+	if r.ReinstallModLoader {
+		fmt.Println("The mod loader will be reinstalled")
+	}
+	if r.ReinstallJava {
+		fmt.Println("Java will be reinstalled")
+	}
+}
+
+func runRepair(ctx context.Context, filename string, args []string) {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments\n\n")
+		PrintCommandUsage(filename, findCommand("repair"))
+		os.Exit(2)
+	}
+	printHeader()
+
+	installPath := Options.Path
+	if len(installPath) == 0 {
+		installPath = "."
+	}
+	if Options.Dryrun {
+		refuseIfInterrupted(installPath)
+	} else {
+		ResumeUpdate(installPath)
+	}
+
+	err, versionInfo := GetVersionInfoFromFile(filepath.Join(installPath, "version.json"))
+	if err != nil {
+		fatalf("Unable to read install at %s: %v\n", installPath, err)
+	}
+
+	printfln("Checking files")
+	err, result := VerifyInstall(installPath)
+	if err != nil {
+		fatalf("Unable to read install at %s: %v\n", installPath, err)
+	}
+
+	err, ml := versionInfo.GetModLoader(ctx)
+	if err != nil {
+		fatalf("Error getting Modloader: %v", err)
+	}
+
+	var java JavaProvider
+	if Options.Nojava {
+		java = &NoOpJavaProvider{}
+	} else {
+		java = versionInfo.GetJavaProvider()
+	}
+
+	printProtected(result.Protected)
+	plan := BuildRepairPlan(ctx, installPath, ml, java, result.Broken, result.Protect)
+	if Options.Dryrun || plan.Empty() {
+		plan.Print()
+		os.Exit(0)
+	}
+
+	printfln("Repairing %d pack files and %d mod loader files", len(plan.PackFiles), len(plan.ModLoaderFiles))
+	if !QuestionYN(true, "Continuing will download the broken files again. Do you wish to continue?") {
+		fatalf("Aborted by user")
+	}
+
+	downloads = append(downloads, plan.PackFiles...)
+	downloads = append(downloads, plan.ModLoaderFiles...)
+	downloads = append(downloads, plan.JavaFiles...)
+	CheckDiskSpace(installPath, RequiredSpace(downloads, plan.JavaFiles))
+	DownloadAll(ctx, installPath)
+
+	if plan.ReinstallJava {
+		emitPhase("java")
+		java.Install(ctx, installPath)
+		stopIfInterrupted(ctx)
+	}
+	if plan.ReinstallModLoader {
+		emitPhase("modloader")
+		ml.Install(ctx, installPath, java)
+		stopIfInterrupted(ctx)
+	}
+
+	printfln("Repaired!")
+	exit(0, "")
+}
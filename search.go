@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type SearchEntry struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	LatestID      int    `json:"latestVersionId,omitempty"`
+	LatestName    string `json:"latestVersion,omitempty"`
+	Minecraft     string `json:"minecraft,omitempty"`
+	ModLoader     string `json:"modloader,omitempty"`
+	ModLoaderVers string `json:"modloaderVersion,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+func Search(ctx context.Context, term string, limit int) (error, []int) {
+	termSafe := url.QueryEscape(term)
+	result := SearchResult{APIResponse: &APIResponse{}}
+	var searchUrl string
+	if Options.Curseforge {
+		searchUrl = fmt.Sprintf(CurseforgeSearchURL, limit, termSafe)
+	} else {
+		searchUrl = fmt.Sprintf(SearchURL, apiKey, limit, termSafe)
+	}
+	if err := APICall(ctx, searchUrl, &result); err != nil {
+		return err, nil
+	}
+	return result.GetError(), result.PackIDs
+}
+
+// GetSearchEntry resolves a pack ID from the search endpoint into the details
+// shown to the user.
+func GetSearchEntry(ctx context.Context, id int) SearchEntry {
+	entry := SearchEntry{ID: id}
+	err, modpack := GetModpack(ctx, id)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.Name = modpack.Name
+
+	err, versionInfo := modpack.GetVersion(ctx, -2)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	if versionInfo.Version != nil {
+		entry.LatestID = versionInfo.ID
+		entry.LatestName = versionInfo.Name
+	}
+	for _, target := range versionInfo.Targets {
+		switch target.Type {
+		case "game":
+			entry.Minecraft = target.Version
+		case "modloader":
+			entry.ModLoader = target.Name
+			entry.ModLoaderVers = target.Version
+		}
+	}
+	return entry
+}
+
+func runSearch(ctx context.Context, filename string, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Missing search term\n\n")
+		PrintCommandUsage(filename, findCommand("search"))
+		os.Exit(2)
+	}
+	if Options.Output == "json" {
+		loggerOut = os.Stderr
+	}
+
+	err, ids := Search(ctx, strings.Join(args, " "), Options.Limit)
+	if err != nil {
+		fatalf("Error searching for modpacks: %v\n", err)
+	}
+
+	entries := make([]SearchEntry, 0, len(ids))
+	for _, id := range ids {
+		LogIfVerbose("Fetching modpack %d\n", id)
+		entries = append(entries, GetSearchEntry(ctx, id))
+		stopIfInterrupted(ctx)
+	}
+
+	if Options.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No modpacks found")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tLATEST\tMINECRAFT\tMODLOADER")
+	for _, e := range entries {
+		if len(e.Error) > 0 {
+			fmt.Fprintf(w, "%d\t%s\t\t\t(error: %s)\n", e.ID, e.Name, e.Error)
+			continue
+		}
+		modLoader := "vanilla"
+		if len(e.ModLoader) > 0 {
+			modLoader = e.ModLoader + " " + e.ModLoaderVers
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s (%d)\t%s\t%s\n", e.ID, e.Name, e.LatestName, e.LatestID, e.Minecraft, modLoader)
+	}
+	w.Flush()
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// stageDir holds the files of an update until all of them are ready, relative
+// to the install path. It is on the same file system as the install, so files
+// are moved into place with a rename.
+var stageDir = filepath.Join(stateDir, "staging")
+
+// journalFile records a staged update, relative to the install path. While it
+// says the update is being applied, the next run finishes applying it.
+var journalFile = filepath.Join(stateDir, "update.json")
+
+const (
+	journalStaging  = "staging"
+	journalApplying = "applying"
+)
+
+// UpdateJournal is the state of a staged update. Applying it only ever
+// removes paths in Delete and moves what is left in the staging folder, so it
+// can be repeated until it completes.
+type UpdateJournal struct {
+	State     string `json:"state"`
+	ModpackID int    `json:"modpackId"`
+	VersionID int    `json:"versionId"`
+	Version   string `json:"version"`
+	// Backup is the snapshot taken before applying, if any.
+	Backup string `json:"backup,omitempty"`
+	// Delete are removed from the install first.
+	Delete []string `json:"delete"`
+	// Replace are folders swapped whole for the staged ones.
+	Replace []string `json:"replace"`
+	// Move are staged files moved into the install, version.json last.
+	Move []string `json:"move"`
+	// Protected were staged but are left as they are in the install.
+	Protected []string `json:"protected,omitempty"`
+}
+
+// StagePath is the absolute staging folder of installPath. Mod loaders use
+// it in download paths, so it must not depend on the working directory.
+func StagePath(installPath string) string {
+	path, err := filepath.Abs(filepath.Join(installPath, stageDir))
+	if err != nil {
+		return filepath.Join(installPath, stageDir)
+	}
+	return path
+}
+
+func ReadJournal(installPath string) (error, *UpdateJournal) {
+	raw, err := os.ReadFile(filepath.Join(installPath, journalFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return err, nil
+	}
+	journal := &UpdateJournal{}
+	if err := json.Unmarshal(raw, journal); err != nil {
+		return fmt.Errorf("unable to read %s: %v", journalFile, err), nil
+	}
+	return nil, journal
+}
+
+// write replaces the journal in one rename, so it is never half written.
+func (j *UpdateJournal) write(installPath string) error {
+	raw, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(installPath, journalFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + partSuffix
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// BeginStaging prepares the staging folder of installPath for an update to
+// versionInfo. Files staged by an earlier attempt at the same version are
+// kept, so partial downloads resume.
+func BeginStaging(installPath string, versionInfo VersionInfo) error {
+	stage := StagePath(installPath)
+	err, journal := ReadJournal(installPath)
+	if err != nil || journal == nil || journal.State != journalStaging || journal.ModpackID != versionInfo.ParentId || journal.VersionID != versionInfo.ID {
+		LogIfVerbose("Clearing %s\n", stage)
+		if err := os.RemoveAll(stage); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(stage, 0755); err != nil {
+		return err
+	}
+	journal = &UpdateJournal{State: journalStaging, ModpackID: versionInfo.ParentId, VersionID: versionInfo.ID, Version: versionInfo.Name}
+	return journal.write(installPath)
+}
+
+// VerifyStaged checks the staged copies of downloads against their hashes,
+// returning those that don't match.
+func VerifyStaged(stage string, downloads []Download) []Download {
+	var bad []Download
+	for _, download := range downloads {
+		filename := download.Filename(stage)
+		if _, err := os.Stat(filename); err != nil {
+			// Installers may move their own downloads, such as Java archives.
+			continue
+		}
+		if !fileMatches(download, filename) {
+			bad = append(bad, download)
+		}
+	}
+	return bad
+}
+
+// fileMatches checks filename against the hash of download, wherever
+// download would normally go.
+func fileMatches(download Download, filename string) bool {
+	check := download
+	check.Path = "."
+	check.Name = filepath.Base(filename)
+	return check.VerifyChecksum(filepath.Dir(filename))
+}
+
+// PrepareApply lists what applying the staged update of plan does: delete the
+// files removed from the pack, swap the folders updates rebuild and move
+// everything else that was staged.
+func PrepareApply(installPath string, plan *InstallPlan) (error, *UpdateJournal) {
+	err, journal := ReadJournal(installPath)
+	if err != nil {
+		return err, nil
+	}
+	if journal == nil {
+		return fmt.Errorf("no update has been staged"), nil
+	}
+	stage := StagePath(installPath)
+	journal.Delete = []string{}
+	journal.Replace = []string{}
+	journal.Move = []string{}
+	journal.Protected = []string{}
+
+	for _, download := range plan.Files.Deleted {
+		if path := filepath.Clean(download.FullPath); safeRelPath(path) {
+			journal.Delete = append(journal.Delete, path)
+			if _, err := os.Stat(pristinePath(installPath, download)); err == nil {
+				journal.Delete = append(journal.Delete, filepath.Join(pristineDir, path))
+			}
+		}
+	}
+	replaced := make(map[string]bool)
+	for _, dir := range backupDirs {
+		if dir == "jre" && Options.Nojava {
+			continue
+		}
+		replaced[dir] = true
+		if plan.Protect.Match(dir, true) {
+			journal.Protected = append(journal.Protected, dir)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(stage, dir)); err == nil {
+			journal.Replace = append(journal.Replace, dir)
+		} else {
+			journal.Delete = append(journal.Delete, dir)
+		}
+	}
+
+	hasVersion := false
+	err = filepath.WalkDir(stage, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(stage, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if d.IsDir() {
+			if replaced[rel] {
+				return filepath.SkipDir
+			}
+			if plan.Protect.Match(rel, true) {
+				journal.Protected = append(journal.Protected, rel)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(rel) == partSuffix {
+			return nil
+		}
+		if rel == "version.json" {
+			hasVersion = true
+			return nil
+		}
+		if plan.Protect.Match(rel, false) {
+			// Written by the mod loader or for the start script.
+			journal.Protected = append(journal.Protected, rel)
+			return nil
+		}
+		if loaderDefaults[rel] && keepLoaderDefault(installPath, plan, rel) {
+			LogIfVerbose("Keeping the existing %s\n", rel)
+			return nil
+		}
+		journal.Move = append(journal.Move, rel)
+		return nil
+	})
+	if err != nil {
+		return err, nil
+	}
+	if !hasVersion {
+		return fmt.Errorf("version.json was not staged"), nil
+	}
+	sort.Strings(journal.Move)
+	journal.Move = append(journal.Move, "version.json")
+	return nil, journal
+}
+
+// loaderDefaults are files the mod loader installers only write when they are
+// missing, for the server owner to edit. Updates install the mod loader into
+// an empty staging folder, so it always writes them.
+var loaderDefaults = map[string]bool{
+	"user_jvm_args.txt": true,
+}
+
+// keepLoaderDefault reports whether the install's copy of the loader default
+// rel should be kept, which it is unless the pack ships the staged copy.
+func keepLoaderDefault(installPath string, plan *InstallPlan, rel string) bool {
+	if _, err := os.Stat(filepath.Join(installPath, rel)); err != nil {
+		return false
+	}
+	for _, download := range plan.VersionInfo.GetDownloads() {
+		if filepath.Clean(download.FullPath) == rel {
+			return !fileMatches(download, filepath.Join(StagePath(installPath), rel))
+		}
+	}
+	return true
+}
+
+// stagePristine stages pristine copies of the pack's configs that were staged,
+// and of unchanged ones the install has no copy of yet, to be moved into place
+// with the rest of the update.
+func stagePristine(installPath string, plan *InstallPlan) error {
+	if err := SavePristine(plan.WorkPath, plan.WorkPath, plan.Files.Downloads(true)); err != nil {
+		return err
+	}
+	var unchanged []Download
+	for _, download := range plan.Files.Unchanged {
+		if !mergeable(download.FullPath) {
+			continue
+		}
+		if _, err := os.Stat(pristinePath(installPath, download)); err == nil {
+			continue
+		}
+		if download.VerifyChecksum(installPath) {
+			unchanged = append(unchanged, download)
+		}
+	}
+	return SavePristine(plan.WorkPath, installPath, unchanged)
+}
+
+// Paths are everything in the install that applying the journal changes.
+func (j *UpdateJournal) Paths() []string {
+	var paths []string
+	paths = append(paths, j.Delete...)
+	paths = append(paths, j.Replace...)
+	paths = append(paths, j.Move...)
+	return paths
+}
+
+// Apply marks the journal as being applied and then applies it. If it is
+// interrupted, calling Apply again on the journal read back picks up from
+// where it stopped.
+func (j *UpdateJournal) Apply(installPath string) error {
+	stage := StagePath(installPath)
+	for _, paths := range [][]string{j.Delete, j.Replace, j.Move} {
+		for _, path := range paths {
+			if !safeRelPath(path) {
+				return fmt.Errorf("%s has an invalid path %s", journalFile, path)
+			}
+		}
+	}
+	if j.State != journalApplying {
+		j.State = journalApplying
+		if err := j.write(installPath); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range j.Delete {
+		target := filepath.Join(installPath, path)
+		LogIfVerbose("Removing %s\n", target)
+		if err := j.backupDir(installPath, path); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		removeEmptyParents(installPath, filepath.Dir(path))
+	}
+
+	for _, path := range j.Replace {
+		staged := filepath.Join(stage, path)
+		if _, err := os.Stat(staged); os.IsNotExist(err) {
+			// Moved on an earlier attempt.
+			continue
+		}
+		target := filepath.Join(installPath, path)
+		LogIfVerbose("Replacing %s\n", target)
+		if err := j.backupDir(installPath, path); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := os.Rename(staged, target); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range j.Move {
+		staged := filepath.Join(stage, path)
+		if _, err := os.Lstat(staged); os.IsNotExist(err) {
+			continue
+		}
+		target := filepath.Join(installPath, path)
+		LogIfVerbose("Moving %s into place\n", target)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(staged, target); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(stage); err != nil {
+		LogIfVerbose("Unable to remove %s: %v\n", stage, err)
+	}
+	return os.Remove(filepath.Join(installPath, journalFile))
+}
+
+// backupDir moves the folder path of the install into the journal's backup,
+// if it is one the backup keeps and it isn't there yet.
+func (j *UpdateJournal) backupDir(installPath string, path string) error {
+	if len(j.Backup) == 0 || !isBackupDir(path) {
+		return nil
+	}
+	dst := filepath.Join(installPath, backupsDir, j.Backup, "files", path)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	src := filepath.Join(installPath, path)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	LogIfVerbose("Backing up %s\n", src)
+	if err := os.Rename(src, dst); err != nil {
+		return copyTree(src, dst)
+	}
+	return nil
+}
+
+// ResumeUpdate finishes applying an update to installPath that was
+// interrupted, so the install is never left part way between two versions.
+func ResumeUpdate(installPath string) {
+	err, journal := ReadJournal(installPath)
+	if err != nil {
+		fatalf("Unable to read the state of the last update: %v", err)
+	}
+	if journal == nil || journal.State != journalApplying {
+		return
+	}
+	printfln("Finishing the interrupted update to version %s", journal.Version)
+	if err := journal.Apply(installPath); err != nil {
+		fatalf("Error finishing the interrupted update: %v", err)
+	}
+	finishBackup(installPath, journal.Backup)
+}
+
+// refuseIfInterrupted stops a command that must not change installPath if an
+// update to it was interrupted whilst being applied, as the install is then
+// part way between two versions.
+func refuseIfInterrupted(installPath string) {
+	err, journal := ReadJournal(installPath)
+	if err != nil {
+		fatalf("Unable to read the state of the last update: %v", err)
+	}
+	if journal != nil && journal.State == journalApplying {
+		fatalf("The update to version %s was interrupted, run update or repair to finish it first", journal.Version)
+	}
+}
+
+// DiscardStaged removes a staged update of installPath that has not been
+// applied, or the journal of one that has been rolled back.
+func DiscardStaged(installPath string) {
+	os.RemoveAll(StagePath(installPath))
+	os.Remove(filepath.Join(installPath, journalFile))
+}
+
+// applyStaged checks the staged update of plan, merges local changes into its
+// configs, snapshots what it replaces and then moves it into place.
+func applyStaged(installPath string, plan *InstallPlan, downloads []Download) {
+	emitPhase("verify")
+	if bad := VerifyStaged(plan.WorkPath, downloads); len(bad) > 0 {
+		for _, download := range bad {
+			printfln("Staged file %s does not match its checksum", download.FullPath)
+		}
+		fatalf("The update could not be verified, nothing was changed")
+	}
+
+	if err := stagePristine(installPath, plan); err != nil {
+		fatalf("Unable to keep pristine copies of the pack's configs, nothing was changed: %v", err)
+	}
+	if len(plan.Merges) > 0 {
+		emitPhase("merge")
+		err, merged, conflicts := mergeStaged(installPath, plan.WorkPath, plan.Merges)
+		if err != nil {
+			fatalf("Unable to merge local changes, nothing was changed: %v", err)
+		}
+		runSummary.Merged = merged
+		runSummary.Conflicts = conflicts
+	}
+	err, journal := PrepareApply(installPath, plan)
+	if err != nil {
+		fatalf("Unable to apply the update, nothing was changed: %v", err)
+	}
+	runSummary.Protected = append(runSummary.Protected, journal.Protected...)
+	if backup := backupUpdate(installPath, plan.Previous, journal.Paths()); backup != nil {
+		journal.Backup = backup.Name()
+	}
+
+	emitPhase("apply")
+	printfln("Applying update...")
+	if err := journal.Apply(installPath); err != nil {
+		fatalf("Error applying the update, run the update again to finish it: %v", err)
+	}
+	finishBackup(installPath, journal.Backup)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, data := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestApplyKeepsLoaderDefaults updates an install whose user_jvm_args.txt was
+// edited, with the mod loader writing its default one into the staging folder.
+func TestApplyKeepsLoaderDefaults(t *testing.T) {
+	const edited = "-Xmx8G\n"
+	const loaderDefault = "# -Xmx4G\n"
+	const packCopy = "-Xmx6G\n"
+	sum := sha1.Sum([]byte(packCopy))
+	shipped := File{Name: "user_jvm_args.txt", Path: "./", URL: "https://example.com/user_jvm_args.txt", SHA1: hex.EncodeToString(sum[:])}
+
+	tests := []struct {
+		name   string
+		files  []File
+		staged string
+		want   string
+	}{
+		{"loader default", nil, loaderDefault, edited},
+		{"shipped by the pack", []File{shipped}, packCopy, packCopy},
+		{"pack copy kept locally", []File{shipped}, loaderDefault, edited},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			installPath := t.TempDir()
+			writeFiles(t, installPath, map[string]string{
+				"version.json":      "{}",
+				"user_jvm_args.txt": edited,
+			})
+			versionInfo := VersionInfo{Version: &Version{ID: 2, Name: "2.0"}, ParentId: 1, Files: test.files}
+			if err := BeginStaging(installPath, versionInfo); err != nil {
+				t.Fatal(err)
+			}
+			stage := StagePath(installPath)
+			writeFiles(t, stage, map[string]string{
+				"version.json":      "{\"id\": 2}",
+				"mods/a.jar":        "a",
+				"user_jvm_args.txt": test.staged,
+			})
+
+			plan := &InstallPlan{InstallPath: installPath, WorkPath: stage, Upgrade: true, VersionInfo: versionInfo}
+			err, journal := PrepareApply(installPath, plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := journal.Apply(installPath); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(installPath, "user_jvm_args.txt"))
+			if err != nil || string(data) != test.want {
+				t.Errorf("user_jvm_args.txt = %q, %v, want %q", data, err, test.want)
+			}
+			if _, err := os.Stat(filepath.Join(installPath, "mods", "a.jar")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cavaliergopher/grab/v3"
+)
+
+// defaultTimeout is how long to wait to connect, for response headers and
+// between reads of a response body, unless --timeout says otherwise.
+const defaultTimeout = 30 * time.Second
+
+// client makes every request, so they all go through the proxy and trust the
+// certificates from the options.
+var client = &http.Client{Transport: timeoutTransport{newTransport(defaultTimeout), defaultTimeout}}
+
+// downloadClient fetches files with client.
+var downloadClient = &grab.Client{
+	HTTPClient: client,
+	UserAgent:  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36 Edg/116.0.1938.69",
+}
+
+// newTransport is the default transport, using the proxy from HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY. Mirrors can be local Maven folders, so it also
+// serves file:// URLs.
+func newTransport(timeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	transport.RegisterProtocol("file", http.NewFileTransport(mavenDir{}))
+	return transport
+}
+
+// configureTransport applies --timeout, --proxy and --cacert to client.
+func configureTransport() error {
+	timeout := time.Duration(Options.Timeout) * time.Second
+	transport := newTransport(timeout)
+	if len(Options.Proxy) > 0 {
+		proxy, err := url.Parse(Options.Proxy)
+		if err != nil || len(proxy.Host) == 0 {
+			return fmt.Errorf("invalid value \"%s\" for --proxy: must be a URL such as http://proxy:3128 or socks5://proxy:1080", Options.Proxy)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid value \"%s\" for --proxy: scheme must be http, https or socks5", Options.Proxy)
+		}
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if noProxy(req.URL.Hostname()) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+	if len(Options.Cacert) > 0 {
+		pem, err := os.ReadFile(Options.Cacert)
+		if err != nil {
+			return fmt.Errorf("unable to read --cacert: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no PEM certificates found in --cacert %s", Options.Cacert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if timeout > 0 {
+		client.Transport = timeoutTransport{transport, timeout}
+	} else {
+		client.Transport = transport
+	}
+	return nil
+}
+
+// errReadTimeout is returned by a response body that sent nothing for the
+// timeout.
+var errReadTimeout error = readTimeoutError{}
+
+type readTimeoutError struct{}
+
+func (readTimeoutError) Error() string   { return "timed out waiting for data" }
+func (readTimeoutError) Timeout() bool   { return true }
+func (readTimeoutError) Temporary() bool { return true }
+
+// timeoutTransport gives up on a response once its body has sent nothing for
+// timeout. Responses can take as long as they like otherwise, so large
+// downloads on slow connections still finish.
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	body := &idleTimeoutBody{ReadCloser: resp.Body, cancel: cancel, timeout: t.timeout}
+	body.timer = time.AfterFunc(t.timeout, body.expire)
+	resp.Body = body
+	return resp, nil
+}
+
+type idleTimeoutBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+func (b *idleTimeoutBody) expire() {
+	atomic.StoreInt32(&b.expired, 1)
+	b.cancel()
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if atomic.LoadInt32(&b.expired) == 1 {
+		return n, errReadTimeout
+	}
+	b.timer.Reset(b.timeout)
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// httpGet makes a GET request for urlStr with client.
+func httpGet(ctx context.Context, urlStr string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// httpHead makes a HEAD request for urlStr with client.
+func httpHead(ctx context.Context, urlStr string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// noProxy reports whether host is reached directly rather than through
+// --proxy: localhost, or a match for NO_PROXY as it is for the environment's
+// proxy.
+func noProxy(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+	list := os.Getenv("NO_PROXY")
+	if len(list) == 0 {
+		list = os.Getenv("no_proxy")
+	}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if len(entry) == 0 {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		host = strings.ToLower(host)
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// grabGet downloads urlStr into dst with downloadClient, like grab.Get.
+func grabGet(ctx context.Context, dst string, urlStr string) (*grab.Response, error) {
+	req, err := grab.NewRequest(dst, urlStr)
+	if err != nil {
+		return nil, err
+	}
+	resp := downloadClient.Do(req.WithContext(ctx))
+	return resp, resp.Err()
+}
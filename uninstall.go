@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// managedDirs are folders in the install root that only ever hold files put
+// there by the installer or the mod loader installers.
+var managedDirs = []string{"libraries", "lib", "jre", "log4jfix", "overrides", stateDir}
+
+// managedFiles are patterns for files in the install root left by the
+// installer, the mod loader installers or the start scripts.
+var managedFiles = []string{
+	"version.json",
+	"start.sh",
+	"start.bat",
+	"run.sh",
+	"run.bat",
+	"user_jvm_args.txt",
+	"installer.log",
+	"overrides.zip",
+	"minecraft_server.*.jar",
+	"forge-*.jar",
+	"forge-*.zip",
+	"forge-*.jar.log",
+	"neoforge-*.jar",
+	"neoforge-*.jar.log",
+	"fabric-*-server-launch.jar",
+	"fabric-server-launcher.properties",
+}
+
+// keptFiles hold server settings and player data, and are never removed even
+// if the pack ships them.
+var keptFiles = map[string]bool{
+	"server.properties":   true,
+	"eula.txt":            true,
+	"ops.json":            true,
+	"whitelist.json":      true,
+	"banned-players.json": true,
+	"banned-ips.json":     true,
+	"usercache.json":      true,
+}
+
+// GetManagedPaths lists everything in installPath that the installer put in
+// place, relative to installPath. Folders are listed after the files in them.
+func GetManagedPaths(installPath string, info VersionInfo) []string {
+	seen := make(map[string]bool)
+	var files []string
+	var dirs []string
+	add := func(path string) {
+		if seen[path] || keptFiles[path] {
+			return
+		}
+		if _, err := os.Lstat(filepath.Join(installPath, path)); err != nil {
+			return
+		}
+		seen[path] = true
+		files = append(files, path)
+	}
+
+	packDirs := make(map[string]bool)
+	for _, down := range info.GetDownloads() {
+		fullPath := filepath.Clean(down.FullPath)
+		add(fullPath)
+		for dir := filepath.Dir(fullPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			packDirs[dir] = true
+		}
+	}
+
+	for _, pattern := range managedFiles {
+		matches, _ := filepath.Glob(filepath.Join(installPath, pattern))
+		for _, match := range matches {
+			if rel, err := filepath.Rel(installPath, match); err == nil {
+				add(rel)
+			}
+		}
+	}
+	for _, dir := range managedDirs {
+		add(dir)
+	}
+
+	for dir := range packDirs {
+		if fi, err := os.Stat(filepath.Join(installPath, dir)); err == nil && fi.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	// Deepest first, so parents are only removed once their children are.
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+
+	sort.Strings(files)
+	return append(files, dirs...)
+}
+
+// Uninstall removes the paths from GetManagedPaths. Pack folders are only
+// removed once they are empty, so anything the user added is kept.
+func Uninstall(installPath string, paths []string) (removed []string, failed int) {
+	for _, path := range paths {
+		fullPath := filepath.Join(installPath, path)
+		fi, err := os.Lstat(fullPath)
+		if err != nil {
+			continue
+		}
+		isManagedDir := false
+		for _, dir := range managedDirs {
+			if path == dir {
+				isManagedDir = true
+			}
+		}
+		if fi.IsDir() && !isManagedDir {
+			entries, err := os.ReadDir(fullPath)
+			if err != nil || len(entries) > 0 {
+				LogIfVerbose("Keeping %s as it is not empty\n", fullPath)
+				continue
+			}
+			err = os.Remove(fullPath)
+		} else {
+			err = os.RemoveAll(fullPath)
+		}
+		if err != nil {
+			printfln("Error occurred whilst removing %s: %v", fullPath, err)
+			failed++
+			continue
+		}
+		LogIfVerbose("Removed %s\n", fullPath)
+		removed = append(removed, path)
+	}
+	return removed, failed
+}
+
+func runUninstall(ctx context.Context, filename string, args []string) {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments\n\n")
+		PrintCommandUsage(filename, findCommand("uninstall"))
+		os.Exit(2)
+	}
+	if Options.Output == "json" {
+		loggerOut = os.Stderr
+	}
+
+	installPath := Options.Path
+	if len(installPath) == 0 {
+		installPath = "."
+	}
+	refuseIfInterrupted(installPath)
+
+	err, info := GetVersionInfoFromFile(filepath.Join(installPath, "version.json"))
+	if err != nil {
+		fatalf("Unable to read install at %s: %v\n", installPath, err)
+	}
+
+	paths := GetManagedPaths(installPath, info)
+	if Options.Dryrun {
+		if Options.Output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(paths)
+			return
+		}
+		fmt.Printf("Would remove (%d), keeping folders that are not empty:\n", len(paths))
+		for _, path := range paths {
+			fmt.Printf("  %s\n", path)
+		}
+		return
+	}
+
+	if !QuestionYN(true, "Continuing will remove %d files and folders installed by the pack from %s. Worlds, server settings and files you added are kept. Do you wish to continue?", len(paths), installPath) {
+		fatalf("Aborted by user")
+	}
+
+	removed, failed := Uninstall(installPath, paths)
+	if Options.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.Encode(removed)
+	}
+	printfln("Removed %d files and folders, %d failed", len(removed), failed)
+	if failed > 0 {
+		exit(1, fmt.Sprintf("%d files and folders could not be removed", failed))
+	}
+	exit(0, "")
+}
@@ -5,6 +5,7 @@ import (
 	"archive/zip"
 	"bufio"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -52,6 +53,7 @@ func QuestionFree(def string, question string, fmtArgs ...interface{}) string {
 
 func Question(def string, choices []string, fixed bool, s string, fmtArgs ...interface{}) string {
 	if Options.Auto == true {
+		emit(Event{Event: "prompt", Question: fmt.Sprintf(s, fmtArgs...), Answer: def})
 		return def
 	}
 
@@ -69,14 +71,13 @@ func Question(def string, choices []string, fixed bool, s string, fmtArgs ...int
 		choicesFmt = fmt.Sprintf("[%v]", def)
 	}
 
-	fmt.Println(fmt.Sprintf(s, fmtArgs...) + fmt.Sprintf(" "+choicesFmt, choicesInt...))
+	fmt.Fprintln(loggerOut, fmt.Sprintf(s, fmtArgs...)+fmt.Sprintf(" "+choicesFmt, choicesInt...))
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Scan()
 	response := scanner.Text()
 	if len(response) == 0 {
-		return def
-	}
-	if fixed {
+		response = def
+	} else if fixed {
 		found := false
 	Free:
 		for i := range choices {
@@ -85,12 +86,12 @@ func Question(def string, choices []string, fixed bool, s string, fmtArgs ...int
 				break Free
 			}
 		}
-		if found {
-			return response
+		if !found {
+			fmt.Fprintln(loggerOut, fmt.Sprintf("\"%s\" is not a valid option.", response))
+			return Question(def, choices, fixed, s, fmtArgs...)
 		}
-		fmt.Println(fmt.Sprintf("\"%s\" is not a valid option.", response))
-		return Question(def, choices, fixed, s, fmtArgs...)
 	}
+	emit(Event{Event: "prompt", Question: fmt.Sprintf(s, fmtArgs...), Answer: response})
 	return response
 }
 
@@ -156,8 +157,8 @@ func UnzipFileToMemory(archive string, filePath string) ([]byte, error) {
 	return bytes, nil
 }
 
-func FileOnServer(urlPath string) bool {
-	resp, err := http.Head(urlPath)
+func FileOnServer(ctx context.Context, urlPath string) bool {
+	resp, err := httpHead(ctx, urlPath)
 	return err == nil && resp.StatusCode == 200
 }
 
@@ -182,13 +183,14 @@ type VanillaManifest struct {
 		Server struct {
 			SHA1 string
 			URL  string
+			Size int64
 		} `json:"server"`
 	} `json:"downloads"`
 }
 
-func (m Minecraft) GetVanillaVersion() (VanillaVersion, error) {
+func (m Minecraft) GetVanillaVersion(ctx context.Context) (VanillaVersion, error) {
 	var ret VanillaVersion
-	resp, err := http.Get(MinecraftMetaURL)
+	resp, err := httpGet(ctx, MinecraftMetaURL)
 	if err == nil {
 		defer resp.Body.Close()
 		bytes, err := io.ReadAll(resp.Body)
@@ -208,9 +210,9 @@ func (m Minecraft) GetVanillaVersion() (VanillaVersion, error) {
 	return ret, err
 }
 
-func (v VanillaVersion) GetServerDownload() (Download, error) {
+func (v VanillaVersion) GetServerDownload(ctx context.Context) (Download, error) {
 	var ret Download
-	resp, err := http.Get(v.URL)
+	resp, err := httpGet(ctx, v.URL)
 	if err == nil {
 		defer resp.Body.Close()
 		bytes, err := io.ReadAll(resp.Body)
@@ -220,7 +222,7 @@ func (v VanillaVersion) GetServerDownload() (Download, error) {
 			if err == nil {
 				URL, err := url.Parse(vanillaManifest.Downloads.Server.URL)
 				if err == nil {
-					ret = Download{"", *URL, "minecraft_server." + v.ID + ".jar", "sha1", vanillaManifest.Downloads.Server.SHA1, filepath.Join("", "minecraft_server."+v.ID+".jar")}
+					ret = Download{"", *URL, "minecraft_server." + v.ID + ".jar", "sha1", vanillaManifest.Downloads.Server.SHA1, filepath.Join("", "minecraft_server."+v.ID+".jar"), nil, vanillaManifest.Downloads.Server.Size}
 				}
 			}
 		}
@@ -380,13 +382,16 @@ func listDirectories(directories []string) []string {
 	return directoryReturn
 }
 
-func getOrBlank(URL string) string {
-	resp, err := http.Get(URL)
+func getOrBlank(ctx context.Context, URL string) string {
+	resp, err := httpGet(ctx, URL)
 	if err != nil {
 		return ""
 	}
 
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
 	bytesRead, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return ""
@@ -394,39 +399,6 @@ func getOrBlank(URL string) string {
 	return string(bytesRead)
 }
 
-func GetMirrorFor(urlStr string, fallback string) string {
-	mirrors := GetMirrors()
-	parsedURL, err := url.Parse(urlStr)
-	if err != nil {
-		printfln("Error parsing mirror url:", err)
-		return urlStr
-	}
-	//todo tidy this up to use the parsed url
-	baseUrlStr := strings.Replace(urlStr, "https://files.minecraftforge.net/maven/", "", 1)
-	baseUrlStr = strings.Replace(baseUrlStr, "https://apps.modpacks.ch/versions/", "", 1)
-	baseUrlStr = strings.Replace(baseUrlStr, "https://maven.minecraftforge.net/", "", 1)
-	baseUrlStr = strings.Replace(baseUrlStr, "https://maven.creeperhost.net/", "", 1)
-	fallbackUrl := fallback + strings.Replace(parsedURL.Path, "/", "", 1)
-	// end of tidy up
-	var fullUrl string
-	for _, mirror := range mirrors {
-		newStr := mirror + baseUrlStr
-		if FileOnServer(newStr) {
-			fullUrl = newStr
-			break
-		}
-	}
-	if fullUrl == "" {
-		if FileOnServer(fallbackUrl) {
-			fullUrl = fallbackUrl
-		} else {
-			fullUrl = urlStr
-		}
-	}
-
-	return fullUrl
-}
-
 func getKey() string {
 	path, err := os.Executable()
 
@@ -465,7 +437,7 @@ func getKey() string {
 	return foundStr
 }
 
-func extractZip(dest string, zipPath string) error {
+func extractZip(ctx context.Context, dest string, zipPath string) error {
 	archive, err := zip.OpenReader(zipPath)
 	if err != nil {
 		return err
@@ -474,6 +446,9 @@ func extractZip(dest string, zipPath string) error {
 	defer archive.Close()
 
 	for _, f := range archive.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		destPath := filepath.Join(dest, f.Name)
 
 		if f.FileInfo().IsDir() {
@@ -510,7 +485,7 @@ func extractZip(dest string, zipPath string) error {
 	return nil
 }
 
-func extractTarGz(dest string, zipPath string) error {
+func extractTarGz(ctx context.Context, dest string, zipPath string) error {
 	file, err := os.Open(zipPath)
 	if err != nil {
 		return err
@@ -522,6 +497,9 @@ func extractTarGz(dest string, zipPath string) error {
 	tarReader := tar.NewReader(uncompressed)
 
 	for true {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
@@ -555,26 +533,6 @@ func extractTarGz(dest string, zipPath string) error {
 	return nil
 }
 
-func mcCleanup(installPath string) {
-	fmt.Println("Running clean up")
-	if !Options.Nojava {
-		if _, err := os.Stat(filepath.Join(installPath, "jre")); !os.IsNotExist(err) {
-			err = os.RemoveAll(filepath.Join(installPath, "jre"))
-			if err != nil {
-				fmt.Println("[ERROR] Unable to remove JRE folder\n", err)
-			}
-		} else {
-			fmt.Println("Jre folder does not exist, no need to clean up")
-		}
-	}
-	if _, err := os.Stat(filepath.Join(installPath, "libraries")); !os.IsNotExist(err) {
-		err = os.RemoveAll(filepath.Join(installPath, "libraries"))
-		if err != nil {
-			fmt.Println("[ERROR] Unable to remove libraries folder\n", err)
-		}
-	}
-}
-
 func printfln(format string, a ...any) {
 	fmt.Fprintln(loggerOut, time.Now().Format("2006/01/02 15:04:05"), "", fmt.Sprintf(format, a...))
 }
@@ -583,8 +541,7 @@ func printf(format string, a ...any) {
 }
 
 func println(a ...any) {
-	fmt.Print()
-	fmt.Println()
+	fmt.Fprintln(loggerOut)
 	fmt.Fprintln(loggerOut, time.Now().Format("2006/01/02 15:04:05"), "", fmt.Sprint(a...))
 }
 func print(a ...any) {
@@ -593,11 +550,11 @@ func print(a ...any) {
 
 func fatal(a ...any) {
 	print(a...)
-	os.Exit(1)
+	exit(1, fmt.Sprint(a...))
 }
 func fatalf(format string, a ...any) {
 	printf(format, a...)
-	os.Exit(1)
+	exit(1, strings.TrimSpace(fmt.Sprintf(format, a...)))
 }
 func LogIfVerbose(str string, a ...any) {
 	if Options.Verbose {
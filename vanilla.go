@@ -1,17 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 )
 
-func (m Minecraft) GetDownloads(installPath string) []Download {
+func (m Minecraft) GetDownloads(ctx context.Context, installPath string) []Download {
 	printfln("Getting downloads for Vanilla")
-	vanillaVer, err := m.GetVanillaVersion()
+	vanillaVer, err := m.GetVanillaVersion(ctx)
 	if err != nil {
 		// handleerr
 	}
 
-	serverDownload, err := vanillaVer.GetServerDownload()
+	serverDownload, err := vanillaVer.GetServerDownload(ctx)
 	if err != nil {
 		// handleerr
 	}
@@ -19,7 +20,7 @@ func (m Minecraft) GetDownloads(installPath string) []Download {
 	return []Download{serverDownload}
 }
 
-func (m Minecraft) Install(installPath string, java JavaProvider) bool {
+func (m Minecraft) Install(ctx context.Context, installPath string, java JavaProvider) bool {
 	return true
 }
 
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ExitDrift is the exit code of verify when the install does not match its
+// version.json.
+const ExitDrift = 3
+
+type VerifyResult struct {
+	InstallPath string   `json:"installPath"`
+	ModpackID   int      `json:"modpackId"`
+	VersionID   int      `json:"versionId"`
+	VersionName string   `json:"version"`
+	Checked     int      `json:"checked"`
+	Modified    []string `json:"modified"`
+	Missing     []string `json:"missing"`
+	Extra       []string `json:"extra"`
+	// Protected are pack files matching the protectFile, which aren't checked.
+	Protected []string `json:"protected"`
+
+	// Broken holds the downloads behind Modified and Missing.
+	Broken  []Download     `json:"-"`
+	Protect ProtectedPaths `json:"-"`
+}
+
+func (r VerifyResult) HasDrift() bool {
+	return len(r.Modified) > 0 || len(r.Missing) > 0 || len(r.Extra) > 0
+}
+
+// VerifyInstall checks every file listed in the version.json of installPath.
+// Files in the same folders as pack files that the pack does not list are
+// reported as extra.
+func VerifyInstall(installPath string) (error, VerifyResult) {
+	result := VerifyResult{InstallPath: installPath, Modified: []string{}, Missing: []string{}, Extra: []string{}, Protected: []string{}}
+
+	err, info := GetVersionInfoFromFile(filepath.Join(installPath, "version.json"))
+	if err != nil {
+		return err, result
+	}
+	result.ModpackID = info.ParentId
+	if info.Version != nil {
+		result.VersionID = info.ID
+		result.VersionName = info.Name
+	}
+
+	err, protect := ReadProtected(installPath)
+	if err != nil {
+		return err, result
+	}
+	result.Protect = protect
+	packFiles, protected := protect.Filter(info.GetDownloads())
+	result.Protected = append(result.Protected, protectedList(uniqueByPath(protected))...)
+
+	known := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, down := range packFiles {
+		fullPath := filepath.Clean(down.FullPath)
+		known[fullPath] = true
+		if dir := filepath.Dir(fullPath); dir != "." {
+			dirs[dir] = true
+		}
+
+		result.Checked++
+		LogIfVerbose("Checking %s\n", fullPath)
+		if _, err := os.Stat(filepath.Join(installPath, fullPath)); os.IsNotExist(err) {
+			result.Missing = append(result.Missing, fullPath)
+			result.Broken = append(result.Broken, down)
+		} else if !down.VerifyChecksum(installPath) {
+			result.Modified = append(result.Modified, fullPath)
+			result.Broken = append(result.Broken, down)
+		}
+	}
+
+	for dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(installPath, dir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			file := filepath.Join(dir, entry.Name())
+			if !entry.IsDir() && !known[file] && !protect.Match(file, false) {
+				result.Extra = append(result.Extra, file)
+			}
+		}
+	}
+
+	sort.Strings(result.Modified)
+	sort.Strings(result.Missing)
+	sort.Strings(result.Extra)
+	return nil, result
+}
+
+func runVerify(ctx context.Context, filename string, args []string) {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments\n\n")
+		PrintCommandUsage(filename, findCommand("verify"))
+		os.Exit(2)
+	}
+	if Options.Output == "json" {
+		loggerOut = os.Stderr
+	}
+
+	installPath := Options.Path
+	if len(installPath) == 0 {
+		installPath = "."
+	}
+	refuseIfInterrupted(installPath)
+
+	err, result := VerifyInstall(installPath)
+	if err != nil {
+		fatalf("Unable to read install at %s: %v\n", installPath, err)
+	}
+
+	if Options.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		fmt.Printf("Checked %d files of %s (modpack %d, version %d) in %s\n", result.Checked, result.VersionName, result.ModpackID, result.VersionID, installPath)
+		section := func(title string, files []string) {
+			if len(files) == 0 {
+				return
+			}
+			fmt.Printf("\n%s (%d):\n", title, len(files))
+			for _, file := range files {
+				fmt.Printf("  %s\n", file)
+			}
+		}
+		section("Modified", result.Modified)
+		section("Missing", result.Missing)
+		section("Not part of the pack", result.Extra)
+		section("Protected, not checked", result.Protected)
+		if !result.HasDrift() {
+			fmt.Println("All files match")
+		}
+	}
+
+	if result.HasDrift() {
+		os.Exit(ExitDrift)
+	}
+}